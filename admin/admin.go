@@ -0,0 +1,270 @@
+// Package admin provides an HTTP API for inspecting and managing a Hub.
+//
+// Endpoints, relative to where the handler is mounted:
+//
+//	GET    /clients              list connected clients
+//	DELETE /clients/{userID}     disconnect a user
+//	GET    /rooms                list all rooms, including private ones
+//	GET    /rooms/{roomID}       room info and members
+//	DELETE /rooms/{roomID}       close a room (?reason=...)
+//	POST   /rooms/{roomID}/kick  kick a user: {"user_id": "...", "reason": "..."}
+//	POST   /broadcast            send a system message: {"room_id": "...", "type": "...", "data": {...}}
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+// Actions passed to the Authorizer
+const (
+	ActionListClients = "clients.list"
+	ActionDisconnect  = "clients.disconnect"
+	ActionListRooms   = "rooms.list"
+	ActionGetRoom     = "rooms.get"
+	ActionCloseRoom   = "rooms.close"
+	ActionKick        = "rooms.kick"
+	ActionBroadcast   = "broadcast"
+)
+
+// Authorizer decides whether a request may perform an action
+type Authorizer interface {
+	Authorize(r *http.Request, action string) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface
+type AuthorizerFunc func(r *http.Request, action string) error
+
+// Authorize calls f(r, action)
+func (f AuthorizerFunc) Authorize(r *http.Request, action string) error {
+	return f(r, action)
+}
+
+// BearerToken returns an Authorizer accepting requests with the given
+// "Authorization: Bearer <token>" header for every action
+func BearerToken(token string) Authorizer {
+	return AuthorizerFunc(func(r *http.Request, action string) error {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return errors.New("unauthorized")
+		}
+		return nil
+	})
+}
+
+// ClientInfo describes a connected client
+type ClientInfo struct {
+	UserID      string    `json:"user_id"`
+	ClientID    string    `json:"client_id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	RemoteIP    string    `json:"remote_ip"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Rooms       []string  `json:"rooms"`
+	LatencyMS   float64   `json:"latency_ms"`
+}
+
+// RoomDetail is a room with its members
+type RoomDetail struct {
+	*websocket.RoomInfo
+	Members []websocket.RoomMember `json:"members"`
+}
+
+// Handler serves the admin API for a hub
+type Handler struct {
+	hub        *websocket.Hub
+	authorizer Authorizer
+}
+
+// NewHandler creates an admin handler. A nil authorizer rejects every
+// request, so the API is never exposed by accident.
+func NewHandler(hub *websocket.Hub, authorizer Authorizer) *Handler {
+	return &Handler{
+		hub:        hub,
+		authorizer: authorizer,
+	}
+}
+
+// ServeHTTP routes admin requests
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "clients" && r.Method == http.MethodGet:
+		h.handle(w, r, ActionListClients, h.listClients)
+	case len(parts) == 2 && parts[0] == "clients" && r.Method == http.MethodDelete:
+		h.handle(w, r, ActionDisconnect, func(r *http.Request) (interface{}, error) {
+			return nil, h.hub.DisconnectUser(parts[1])
+		})
+	case len(parts) == 1 && parts[0] == "rooms" && r.Method == http.MethodGet:
+		h.handle(w, r, ActionListRooms, h.listRooms)
+	case len(parts) == 2 && parts[0] == "rooms" && r.Method == http.MethodGet:
+		h.handle(w, r, ActionGetRoom, func(r *http.Request) (interface{}, error) {
+			return h.getRoom(parts[1])
+		})
+	case len(parts) == 2 && parts[0] == "rooms" && r.Method == http.MethodDelete:
+		h.handle(w, r, ActionCloseRoom, func(r *http.Request) (interface{}, error) {
+			return nil, h.closeRoom(parts[1], r.URL.Query().Get("reason"))
+		})
+	case len(parts) == 3 && parts[0] == "rooms" && parts[2] == "kick" && r.Method == http.MethodPost:
+		h.handle(w, r, ActionKick, func(r *http.Request) (interface{}, error) {
+			return nil, h.kick(parts[1], r)
+		})
+	case len(parts) == 1 && parts[0] == "broadcast" && r.Method == http.MethodPost:
+		h.handle(w, r, ActionBroadcast, h.broadcast)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// errNotFound marks errors that map to 404
+type errNotFound struct{ msg string }
+
+func (e errNotFound) Error() string { return e.msg }
+
+// handle authorizes the request, runs fn and writes its JSON result
+func (h *Handler) handle(w http.ResponseWriter, r *http.Request, action string, fn func(*http.Request) (interface{}, error)) {
+	if h.authorizer == nil {
+		writeError(w, http.StatusForbidden, errors.New("admin API has no authorizer"))
+		return
+	}
+	if err := h.authorizer.Authorize(r, action); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+
+	result, err := fn(r)
+	if err != nil {
+		status := http.StatusBadRequest
+		var notFound errNotFound
+		if errors.As(err, &notFound) {
+			status = http.StatusNotFound
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	if result == nil {
+		result = map[string]bool{"ok": true}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (h *Handler) listClients(r *http.Request) (interface{}, error) {
+	users := h.hub.GetOnlineUsers()
+	sort.Strings(users)
+
+	clients := make([]ClientInfo, 0, len(users))
+	for _, userID := range users {
+		client := h.hub.GetClient(userID)
+		if client == nil {
+			continue
+		}
+		rooms := h.hub.GetUserRooms(userID)
+		sort.Strings(rooms)
+
+		clients = append(clients, ClientInfo{
+			UserID:      client.UserID,
+			ClientID:    client.ID,
+			RemoteAddr:  client.RemoteAddr(),
+			RemoteIP:    client.RemoteIP(),
+			ConnectedAt: client.ConnectedAt,
+			Rooms:       rooms,
+			LatencyMS:   float64(client.Latency()) / float64(time.Millisecond),
+		})
+	}
+	return clients, nil
+}
+
+func (h *Handler) listRooms(r *http.Request) (interface{}, error) {
+	rooms := h.hub.ListAllRooms()
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].CreatedAt.Before(rooms[j].CreatedAt) })
+	return rooms, nil
+}
+
+func (h *Handler) getRoom(roomID string) (interface{}, error) {
+	room := h.hub.GetRoom(roomID)
+	if room == nil {
+		return nil, errNotFound{"room not found"}
+	}
+	return RoomDetail{
+		RoomInfo: room.ToInfo(),
+		Members:  h.hub.GetRoomMembers(roomID),
+	}, nil
+}
+
+func (h *Handler) closeRoom(roomID, reason string) error {
+	if !h.hub.RoomExists(roomID) {
+		return errNotFound{"room not found"}
+	}
+	if reason == "" {
+		reason = websocket.CloseReasonManual
+	}
+	h.hub.CloseRoomWithReason(roomID, reason)
+	return nil
+}
+
+func (h *Handler) kick(roomID string, r *http.Request) error {
+	var req struct {
+		UserID string `json:"user_id"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	if req.UserID == "" {
+		return errors.New("user_id required")
+	}
+	if !h.hub.RoomExists(roomID) {
+		return errNotFound{"room not found"}
+	}
+	err := h.hub.KickFromRoom(req.UserID, roomID, req.Reason)
+	if errors.Is(err, websocket.ErrNotInRoom) {
+		return errNotFound{err.Error()}
+	}
+	return err
+}
+
+func (h *Handler) broadcast(r *http.Request) (interface{}, error) {
+	var req struct {
+		RoomID string                 `json:"room_id"`
+		Type   string                 `json:"type"`
+		Data   map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Type == "" {
+		req.Type = "system"
+	}
+	msg := websocket.Message{Type: req.Type, Data: req.Data}
+
+	if req.RoomID != "" {
+		if !h.hub.RoomExists(req.RoomID) {
+			return nil, errNotFound{"room not found"}
+		}
+		h.hub.BroadcastToRoom(req.RoomID, msg)
+		return nil, nil
+	}
+
+	h.hub.BroadcastToAll(msg)
+	return nil, nil
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
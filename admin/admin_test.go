@@ -0,0 +1,153 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// connect dials a test server and waits until the hub has registered the user
+func connect(t *testing.T, hub *websocket.Hub, server *httptest.Server, userID string) *gorilla.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "?user_id=" + userID
+	conn, _, err := gorilla.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && hub.GetClient(userID) == nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return conn
+}
+
+func request(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAPI(t *testing.T) {
+	hub := websocket.NewHub(nil)
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.HandleConnection(hub, w, r, r.URL.Query().Get("user_id"))
+	}))
+	defer server.Close()
+
+	conn := connect(t, hub, server, "alice")
+	defer conn.Close()
+
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Secret", IsPrivate: true})
+	hub.JoinRoomWithMember("alice", roomID, &websocket.RoomMember{DisplayName: "Alice"})
+
+	handler := NewHandler(hub, BearerToken("secret"))
+
+	t.Run("unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/clients", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", rec.Code)
+		}
+
+		rec = httptest.NewRecorder()
+		NewHandler(hub, nil).ServeHTTP(rec, httptest.NewRequest("GET", "/clients", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 without authorizer, got %d", rec.Code)
+		}
+
+		for _, header := range []string{"Bearer secre", "Bearer secrets", "secret", "Basic secret"} {
+			req := httptest.NewRequest("GET", "/clients", nil)
+			req.Header.Set("Authorization", header)
+			if err := BearerToken("secret").Authorize(req, ActionListClients); err == nil {
+				t.Errorf("Expected %q to be rejected", header)
+			}
+		}
+	})
+
+	t.Run("list clients", func(t *testing.T) {
+		rec := request(handler, "GET", "/clients", "")
+		var clients []ClientInfo
+		json.Unmarshal(rec.Body.Bytes(), &clients)
+
+		if len(clients) != 1 || clients[0].UserID != "alice" {
+			t.Fatalf("Expected alice, got %s", rec.Body.String())
+		}
+		if clients[0].RemoteAddr == "" || len(clients[0].Rooms) != 1 {
+			t.Errorf("Expected remote address and room, got %+v", clients[0])
+		}
+	})
+
+	t.Run("private rooms and members", func(t *testing.T) {
+		rec := request(handler, "GET", "/rooms", "")
+		if !strings.Contains(rec.Body.String(), roomID) {
+			t.Errorf("Expected private room in list, got %s", rec.Body.String())
+		}
+
+		rec = request(handler, "GET", "/rooms/"+roomID, "")
+		var detail struct {
+			Name    string                 `json:"name"`
+			Members []websocket.RoomMember `json:"members"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &detail)
+		if detail.Name != "Secret" || len(detail.Members) != 1 || detail.Members[0].DisplayName != "Alice" {
+			t.Errorf("Unexpected room detail %s", rec.Body.String())
+		}
+
+		if rec := request(handler, "GET", "/rooms/missing", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("broadcast", func(t *testing.T) {
+		rec := request(handler, "POST", "/broadcast", `{"type":"maintenance","data":{"in":"5m"}}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var msg websocket.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Type == "maintenance" {
+				break
+			}
+		}
+	})
+
+	t.Run("kick non-member", func(t *testing.T) {
+		rec := request(handler, "POST", "/rooms/"+roomID+"/kick", `{"user_id":"mallory"}`)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("close room and disconnect", func(t *testing.T) {
+		if rec := request(handler, "DELETE", "/rooms/"+roomID+"?reason=moderation", ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", rec.Code)
+		}
+		if hub.RoomExists(roomID) {
+			t.Error("Expected room to be closed")
+		}
+
+		if rec := request(handler, "DELETE", "/clients/alice", ""); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", rec.Code)
+		}
+		for i := 0; i < 100 && hub.GetClient("alice") != nil; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if hub.GetClient("alice") != nil {
+			t.Error("Expected alice to be disconnected")
+		}
+	})
+}
@@ -0,0 +1,418 @@
+// Package bench load-tests a Hub. It starts a hub on a local HTTP server,
+// connects simulated clients over loopback WebSockets, puts them in rooms
+// and has every client broadcast to its room at a fixed rate, measuring
+// delivery latency, losses, memory and goroutines.
+//
+// Clients run in the same process as the hub, so memory and goroutine
+// figures include both ends of every connection.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// messageType is the type of benchmark messages
+const messageType = "bench"
+
+// Config describes a load test
+type Config struct {
+	Clients     int               // simulated clients, default 100
+	RoomSize    int               // clients per room, default 10
+	Rate        float64           // messages per second per client, default 1
+	Duration    time.Duration     // how long to send, default 10s
+	MessageSize int               // payload bytes per message, default 64
+	Drain       time.Duration     // how long to wait for in-flight messages, default 2s
+	Hub         *websocket.Config // hub configuration, nil = defaults
+}
+
+// Result is the outcome of a load test
+type Result struct {
+	Clients  int
+	Rooms    int
+	Duration time.Duration
+
+	Sent         uint64 // messages sent by clients
+	Expected     uint64 // deliveries expected from the fan-out
+	Received     uint64 // deliveries received
+	Lost         uint64 // Expected - Received
+	Dropped      uint64 // messages the hub dropped for full send buffers
+	Disconnected int    // clients the hub disconnected during the run
+
+	P50, P90, P99, Max time.Duration // delivery latency
+
+	HeapPerClient  uint64 // heap bytes per connection after connecting
+	StackPerClient uint64 // goroutine stack bytes per connection
+	Goroutines     int    // goroutines per connection after connecting
+}
+
+// Throughput returns deliveries per second
+func (r *Result) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Received) / r.Duration.Seconds()
+}
+
+// Report writes a human readable summary
+func (r *Result) Report(w io.Writer) {
+	fmt.Fprintf(w, "clients       %d in %d rooms\n", r.Clients, r.Rooms)
+	fmt.Fprintf(w, "duration      %v\n", r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "sent          %d\n", r.Sent)
+	fmt.Fprintf(w, "delivered     %d of %d (%.0f/s)\n", r.Received, r.Expected, r.Throughput())
+	fmt.Fprintf(w, "lost          %d (hub dropped %d, %d clients disconnected)\n", r.Lost, r.Dropped, r.Disconnected)
+	fmt.Fprintf(w, "latency       p50 %v  p90 %v  p99 %v  max %v\n", r.P50, r.P90, r.P99, r.Max)
+	fmt.Fprintf(w, "memory        %.1f KiB heap, %.1f KiB stack per connection\n", float64(r.HeapPerClient)/1024, float64(r.StackPerClient)/1024)
+	fmt.Fprintf(w, "goroutines    %d per connection\n", r.Goroutines)
+}
+
+func (c *Config) applyDefaults() {
+	if c.Clients <= 0 {
+		c.Clients = 100
+	}
+	if c.RoomSize <= 0 {
+		c.RoomSize = 10
+	}
+	if c.Rate <= 0 {
+		c.Rate = 1
+	}
+	if c.Duration <= 0 {
+		c.Duration = 10 * time.Second
+	}
+	if c.MessageSize < 0 {
+		c.MessageSize = 0
+	} else if c.MessageSize == 0 {
+		c.MessageSize = 64
+	}
+	if c.Drain <= 0 {
+		c.Drain = 2 * time.Second
+	}
+}
+
+// dropCounter counts messages the hub drops
+type dropCounter struct {
+	dropped atomic.Uint64
+}
+
+func (d *dropCounter) MessageReceived(msgType string, bytes int)                 {}
+func (d *dropCounter) MessageSent(msgType string, bytes int)                     {}
+func (d *dropCounter) MessageDropped(msgType string)                             { d.dropped.Add(1) }
+func (d *dropCounter) BroadcastCompleted(recipients int, duration time.Duration) {}
+func (d *dropCounter) UpgradeFailed(reason string)                               {}
+
+// benchClient is one simulated connection
+type benchClient struct {
+	userID    string
+	roomID    string
+	conn      *gorilla.Conn
+	latencies []time.Duration
+	received  uint64
+	failed    bool
+	writeMu   sync.Mutex
+}
+
+// Run performs a load test. Cancelling ctx ends the sending phase early.
+func Run(ctx context.Context, config Config) (*Result, error) {
+	config.applyDefaults()
+
+	hubConfig := websocket.DefaultConfig()
+	if config.Hub != nil {
+		copied := *config.Hub
+		hubConfig = &copied
+	}
+	drops := &dropCounter{}
+	if hubConfig.Metrics == nil {
+		hubConfig.Metrics = drops
+	}
+
+	hub := websocket.NewHub(hubConfig)
+	go hub.Run()
+	defer hub.Close()
+
+	// Clients broadcast bench messages to their room
+	hub.OnMessage(func(ctx context.Context, client *websocket.Client, msg websocket.Message) {
+		if msg.Type != messageType {
+			return
+		}
+		roomID, _ := msg.Data["room_id"].(string)
+		hub.BroadcastToRoom(roomID, msg)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.HandleConnection(hub, w, r, r.URL.Query().Get("user_id"))
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	baseline := snapshot()
+
+	clients, err := connect(url, config)
+	defer func() {
+		for _, c := range clients {
+			c.conn.Close()
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+	if err := waitOnline(hub, len(clients)); err != nil {
+		return nil, err
+	}
+
+	// Assign rooms
+	roomSizes := make(map[string]uint64)
+	var roomID string
+	for i, c := range clients {
+		if i%config.RoomSize == 0 {
+			roomID = hub.CreateRoom(&websocket.RoomConfig{
+				Name:      "bench-" + strconv.Itoa(i/config.RoomSize),
+				Lifecycle: websocket.Persistent,
+			})
+		}
+		c.roomID = roomID
+		if err := hub.JoinRoom(c.userID, roomID); err != nil {
+			return nil, fmt.Errorf("join %s: %w", c.userID, err)
+		}
+		roomSizes[roomID]++
+	}
+
+	connected := snapshot()
+
+	// Readers record latencies until the connection closes
+	var readers sync.WaitGroup
+	for _, c := range clients {
+		readers.Add(1)
+		go func(c *benchClient) {
+			defer readers.Done()
+			c.read()
+		}(c)
+	}
+
+	// Senders broadcast at the configured rate
+	payload := strings.Repeat("x", config.MessageSize)
+	interval := time.Duration(float64(time.Second) / config.Rate)
+	sendCtx, cancel := context.WithTimeout(ctx, config.Duration)
+	defer cancel()
+
+	var sent, expected atomic.Uint64
+	var senders sync.WaitGroup
+	start := time.Now()
+	for i, c := range clients {
+		senders.Add(1)
+		go func(c *benchClient, offset time.Duration) {
+			defer senders.Done()
+
+			// Spread clients over the interval instead of sending in bursts
+			select {
+			case <-time.After(offset):
+			case <-sendCtx.Done():
+				return
+			}
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				if err := c.send(payload); err != nil {
+					return
+				}
+				sent.Add(1)
+				expected.Add(roomSizes[c.roomID])
+
+				select {
+				case <-ticker.C:
+				case <-sendCtx.Done():
+					return
+				}
+			}
+		}(c, interval*time.Duration(i)/time.Duration(len(clients)))
+	}
+	senders.Wait()
+	elapsed := time.Since(start)
+
+	// Wait for in-flight deliveries, then close to stop the readers
+	deadline := time.Now().Add(config.Drain)
+	for time.Now().Before(deadline) && received(clients) < expected.Load() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	readers.Wait()
+
+	// Let the hub release the connections, so back-to-back runs don't
+	// measure each other
+	waitOnline(hub, 0)
+
+	result := &Result{
+		Clients:  len(clients),
+		Rooms:    len(roomSizes),
+		Duration: elapsed,
+		Sent:     sent.Load(),
+		Expected: expected.Load(),
+		Received: received(clients),
+		Dropped:  drops.dropped.Load(),
+	}
+	if result.Expected > result.Received {
+		result.Lost = result.Expected - result.Received
+	}
+
+	var latencies []time.Duration
+	for _, c := range clients {
+		latencies = append(latencies, c.latencies...)
+		if c.failed {
+			result.Disconnected++
+		}
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.P50 = percentile(latencies, 0.50)
+		result.P90 = percentile(latencies, 0.90)
+		result.P99 = percentile(latencies, 0.99)
+		result.Max = latencies[len(latencies)-1]
+	}
+
+	if connected.heap > baseline.heap {
+		result.HeapPerClient = (connected.heap - baseline.heap) / uint64(len(clients))
+	}
+	if connected.stack > baseline.stack {
+		result.StackPerClient = (connected.stack - baseline.stack) / uint64(len(clients))
+	}
+	result.Goroutines = (connected.goroutines - baseline.goroutines) / len(clients)
+
+	return result, nil
+}
+
+// connect dials all clients, a few at a time
+func connect(url string, config Config) ([]*benchClient, error) {
+	clients := make([]*benchClient, config.Clients)
+	errs := make(chan error, config.Clients)
+	sem := make(chan struct{}, 32)
+
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() { <-sem; wg.Done() }()
+
+			userID := "bench-" + strconv.Itoa(i)
+			conn, _, err := gorilla.DefaultDialer.Dial(url+"?user_id="+userID, nil)
+			if err != nil {
+				errs <- fmt.Errorf("dial %s: %w", userID, err)
+				return
+			}
+			clients[i] = &benchClient{userID: userID, conn: conn}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	// Return what connected so the caller can close it
+	connected := clients[:0]
+	for _, c := range clients {
+		if c != nil {
+			connected = append(connected, c)
+		}
+	}
+	return connected, <-errs
+}
+
+// waitOnline waits until the hub has n clients registered
+func waitOnline(hub *websocket.Hub, n int) error {
+	deadline := time.Now().Add(30 * time.Second)
+	for hub.GetOnlineCount() != n {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %d clients online, have %d", n, hub.GetOnlineCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return nil
+}
+
+// send writes one benchmark message stamped with the send time
+func (c *benchClient) send(payload string) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(websocket.Message{
+		Type: messageType,
+		Data: map[string]interface{}{
+			"room_id": c.roomID,
+			"sent":    strconv.FormatInt(time.Now().UnixNano(), 10),
+			"payload": payload,
+		},
+	})
+}
+
+// read records the latency of every benchmark message until the
+// connection closes
+func (c *benchClient) read() {
+	for {
+		var msg websocket.Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			// A close frame means the hub disconnected us
+			var closeErr *gorilla.CloseError
+			c.failed = errors.As(err, &closeErr)
+			return
+		}
+		if msg.Type != messageType {
+			continue
+		}
+
+		sent, _ := msg.Data["sent"].(string)
+		nanos, err := strconv.ParseInt(sent, 10, 64)
+		if err != nil {
+			continue
+		}
+		c.latencies = append(c.latencies, time.Since(time.Unix(0, nanos)))
+		atomic.AddUint64(&c.received, 1)
+	}
+}
+
+// received sums deliveries across clients
+func received(clients []*benchClient) uint64 {
+	var total uint64
+	for _, c := range clients {
+		total += atomic.LoadUint64(&c.received)
+	}
+	return total
+}
+
+// usage is a point-in-time resource reading
+type usage struct {
+	heap       uint64
+	stack      uint64
+	goroutines int
+}
+
+func snapshot() usage {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return usage{heap: stats.HeapAlloc, stack: stats.StackInuse, goroutines: runtime.NumGoroutine()}
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
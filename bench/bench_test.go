@@ -0,0 +1,109 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+func TestRun(t *testing.T) {
+	result, err := Run(context.Background(), Config{
+		Clients:  12,
+		RoomSize: 5,
+		Rate:     20,
+		Duration: 300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Rooms != 3 {
+		t.Errorf("Expected 3 rooms, got %d", result.Rooms)
+	}
+	if result.Sent == 0 || result.Received != result.Expected || result.Lost != 0 {
+		t.Errorf("Expected every message delivered, got %+v", result)
+	}
+	if result.P50 <= 0 || result.Max < result.P99 {
+		t.Errorf("Unexpected latencies %+v", result)
+	}
+	if result.Goroutines < 2 {
+		t.Errorf("Expected at least the two pump goroutines per connection, got %d", result.Goroutines)
+	}
+
+	var out bytes.Buffer
+	result.Report(&out)
+	if !strings.Contains(out.String(), "12 in 3 rooms") {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+}
+
+func TestRunNetpoll(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("netpoll mode requires Linux")
+	}
+
+	hub := websocket.DefaultConfig()
+	hub.Netpoll = &websocket.NetpollConfig{}
+	result, err := Run(context.Background(), Config{
+		Clients:  12,
+		RoomSize: 5,
+		Rate:     20,
+		Duration: 300 * time.Millisecond,
+		Hub:      hub,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Sent == 0 || result.Received != result.Expected {
+		t.Errorf("Expected every message delivered, got %+v", result)
+	}
+	if result.Goroutines != 0 {
+		t.Errorf("Expected no goroutines per connection, got %d", result.Goroutines)
+	}
+}
+
+// BenchmarkConnectionMemory compares heap and goroutines per idle
+// connection with and without netpoll mode
+func BenchmarkConnectionMemory(b *testing.B) {
+	modes := []struct {
+		name    string
+		netpoll *websocket.NetpollConfig
+	}{
+		{"goroutines", nil},
+		{"netpoll", &websocket.NetpollConfig{}},
+	}
+
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			hub := websocket.DefaultConfig()
+			hub.Netpoll = mode.netpoll
+
+			var heap, stack uint64
+			var goroutines int
+			for i := 0; i < b.N; i++ {
+				result, err := Run(context.Background(), Config{
+					Clients:  2000,
+					RoomSize: 50,
+					Rate:     0.1,
+					Duration: 10 * time.Millisecond,
+					Hub:      hub,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				heap += result.HeapPerClient
+				stack += result.StackPerClient
+				goroutines += result.Goroutines
+			}
+			b.ReportMetric(float64(heap)/float64(b.N), "heap-B/conn")
+			b.ReportMetric(float64(stack)/float64(b.N), "stack-B/conn")
+			b.ReportMetric(float64(goroutines)/float64(b.N), "goroutines/conn")
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,17 +12,52 @@ import (
 
 // Client represents a WebSocket client connection
 type Client struct {
-	ID       string
-	UserID   string
-	Hub      *Hub
-	Conn     *websocket.Conn
-	Send     chan Message
+	ID     string
+	UserID string
+	Hub    *Hub
+	Conn   Conn
+	Send   chan Message
+
+	// Deprecated: reading these maps races with joins and hooks; use
+	// InRoom, RoomList and the *Metadata methods.
 	Rooms    map[string]bool
 	Metadata map[string]interface{}
+	mu       sync.RWMutex // guards Rooms and Metadata
+
+	// Send is closed once, under sendMu, so senders never hit a closed channel
+	sendClosed bool
+	sendMu     sync.RWMutex
+
+	// Schedules a write of queued messages in netpoll mode, nil otherwise
+	wake func()
+
+	// Closed by Run once the client is registered
+	registered chan struct{}
+
+	ConnectedAt time.Time
+
+	// Connection context, cancelled on disconnect
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Upgrade request details, nil without HandleConnection
+	handshake *Handshake
+
+	// Round-trip time from the last ping/pong
+	latency     time.Duration
+	highLatency bool
+	latencyMu   sync.Mutex
 }
 
 // NewClient creates a new WebSocket client
-func NewClient(hub *Hub, conn *websocket.Conn, userID string) *Client {
+func NewClient(hub *Hub, conn Conn, userID string) *Client {
+	return newClient(context.Background(), hub, conn, userID)
+}
+
+// newClient creates a client whose context is derived from ctx
+func newClient(ctx context.Context, hub *Hub, conn Conn, userID string) *Client {
+	ctx, cancel := context.WithCancel(ctx)
+
 	return &Client{
 		ID:       generateID(),
 		UserID:   userID,
@@ -29,19 +66,104 @@ func NewClient(hub *Hub, conn *websocket.Conn, userID string) *Client {
 		Send:     make(chan Message, 256),
 		Rooms:    make(map[string]bool),
 		Metadata: make(map[string]interface{}),
+
+		registered: make(chan struct{}),
+
+		ConnectedAt: hub.clock.Now(),
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Context returns the connection's context. It carries the values of the
+// HTTP upgrade request and is cancelled when the client disconnects.
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// RemoteAddr returns the network address of the client, if connected
+func (c *Client) RemoteAddr() string {
+	if c.Conn == nil {
+		return ""
 	}
+	return c.Conn.RemoteAddr().String()
+}
+
+// InRoom reports whether the client is in a room
+func (c *Client) InRoom(roomID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Rooms[roomID]
+}
+
+// RoomList returns the IDs of the rooms the client is in
+func (c *Client) RoomList() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rooms := make([]string, 0, len(c.Rooms))
+	for roomID := range c.Rooms {
+		rooms = append(rooms, roomID)
+	}
+	return rooms
+}
+
+// addRoom records that the client joined a room
+func (c *Client) addRoom(roomID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Rooms[roomID] = true
+}
+
+// removeRoom records that the client left a room
+func (c *Client) removeRoom(roomID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.Rooms, roomID)
+}
+
+// GetMetadata returns a metadata value of the client
+func (c *Client) GetMetadata(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	value, ok := c.Metadata[key]
+	return value, ok
+}
+
+// SetMetadata sets a metadata value of the client
+func (c *Client) SetMetadata(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Metadata[key] = value
+}
+
+// DeleteMetadata removes a metadata value of the client
+func (c *Client) DeleteMetadata(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.Metadata, key)
+}
+
+// logger returns the hub logger with this client's attributes
+func (c *Client) logger() *slog.Logger {
+	return c.Hub.logger.With("user_id", c.UserID, "client_id", c.ID)
 }
 
 // ReadPump reads messages from the WebSocket connection
 func (c *Client) ReadPump() {
 	defer func() {
-		c.Hub.Unregister <- c
+		select {
+		case c.Hub.Unregister <- c:
+		case <-c.Hub.done:
+		}
 		c.Conn.Close()
 	}()
 
 	c.Conn.SetReadDeadline(time.Now().Add(c.Hub.config.PongWait))
-	c.Conn.SetPongHandler(func(string) error {
+	c.Conn.SetPongHandler(func(appData string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(c.Hub.config.PongWait))
+		c.recordPong(appData)
 		return nil
 	})
 	c.Conn.SetReadLimit(c.Hub.config.MaxMessageSize)
@@ -50,25 +172,38 @@ func (c *Client) ReadPump() {
 		_, messageBytes, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				c.logger().Warn("websocket read error", "error", err)
 			}
 			break
 		}
 
-		var msg Message
-		if err := json.Unmarshal(messageBytes, &msg); err != nil {
-			log.Printf("Error unmarshaling message: %v", err)
-			continue
+		c.handleData(messageBytes)
+	}
+}
+
+// handleData decodes a message read from the connection and hands it to
+// the hub
+func (c *Client) handleData(messageBytes []byte) {
+	var msg Message
+	if err := json.Unmarshal(messageBytes, &msg); err != nil {
+		c.logger().Warn("invalid message", "error", err)
+		if c.Hub.config.Metrics != nil {
+			c.Hub.config.Metrics.MessageReceived("invalid", len(messageBytes))
 		}
+		return
+	}
 
-		// Handle message through hub
-		c.Hub.HandleMessage(c, msg)
+	if c.Hub.config.Metrics != nil {
+		c.Hub.config.Metrics.MessageReceived(msg.Type, len(messageBytes))
 	}
+
+	// Handle message through hub
+	c.Hub.HandleMessage(c, msg)
 }
 
 // WritePump writes messages to the WebSocket connection
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(c.Hub.config.PingInterval)
+	ticker := c.Hub.clock.NewTicker(c.Hub.config.PingInterval)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
@@ -80,24 +215,28 @@ func (c *Client) WritePump() {
 			c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.config.WriteWait))
 			if !ok {
 				// Hub closed the channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.Conn.WriteMessage(CloseMessage, []byte{})
 				return
 			}
 
 			// Send message
 			messageBytes, err := json.Marshal(message)
 			if err != nil {
-				log.Printf("Error marshaling message: %v", err)
+				c.logger().Error("failed to marshal message", "type", message.Type, "error", err)
 				continue
 			}
 
-			if err := c.Conn.WriteMessage(websocket.TextMessage, messageBytes); err != nil {
+			if err := c.Conn.WriteMessage(TextMessage, messageBytes); err != nil {
 				return
 			}
 
-		case <-ticker.C:
+			if c.Hub.config.Metrics != nil {
+				c.Hub.config.Metrics.MessageSent(message.Type, len(messageBytes))
+			}
+
+		case <-ticker.C():
 			c.Conn.SetWriteDeadline(time.Now().Add(c.Hub.config.WriteWait))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.Conn.WriteMessage(PingMessage, pingPayload(c.Hub.clock.Now())); err != nil {
 				return
 			}
 		}
@@ -106,18 +245,51 @@ func (c *Client) WritePump() {
 
 // SendMessage sends a message to this client
 func (c *Client) SendMessage(msg Message) {
-	defer func() {
-		if r := recover(); r != nil {
-			// Channel already closed, client disconnected
-			log.Printf("Failed to send message to client %s: %v", c.UserID, r)
+	c.sendMu.RLock()
+	if c.sendClosed {
+		c.sendMu.RUnlock()
+		c.logger().Debug("send to disconnected client", "type", msg.Type)
+		if c.Hub.config.Metrics != nil {
+			c.Hub.config.Metrics.MessageDropped(msg.Type)
 		}
-	}()
-	
+		return
+	}
+
 	select {
 	case c.Send <- msg:
+		c.sendMu.RUnlock()
+		if c.wake != nil {
+			c.wake()
+		}
+		return
 	default:
-		// Channel full, client too slow - close it
-		close(c.Send)
-		c.Hub.Unregister <- c
 	}
+	c.sendMu.RUnlock()
+
+	// Channel full, client too slow - disconnect it
+	c.logger().Warn("send buffer full, disconnecting client", "type", msg.Type)
+	if c.Hub.config.Metrics != nil {
+		c.Hub.config.Metrics.MessageDropped(msg.Type)
+	}
+	if c.closeSend() {
+		// Callers may hold room locks that unregistering needs
+		go c.Hub.unregisterClient(c)
+	}
+}
+
+// closeSend closes the Send channel, which makes WritePump (or the netpoll
+// workers) close the connection. It reports whether this call closed it.
+func (c *Client) closeSend() bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.sendClosed {
+		return false
+	}
+	c.sendClosed = true
+	close(c.Send)
+	if c.wake != nil {
+		c.wake()
+	}
+	return true
 }
@@ -0,0 +1,475 @@
+// Package client connects Go services and bots to a Hub. It speaks the
+// websocket.Message envelope, reconnects with exponential backoff and
+// jitter, and re-joins its rooms after reconnecting.
+//
+// The server decides how clients join rooms. By default Join sends a
+// room.join message with a room_id. The hub does not handle room.join or
+// room.leave itself but passes them to its onMessage hook, so the server
+// routes them:
+//
+//	hub.OnMessage(func(ctx context.Context, c *websocket.Client, msg websocket.Message) {
+//		if msg.Type == client.JoinType {
+//			roomID, _ := msg.Data["room_id"].(string)
+//			hub.JoinRoom(c.UserID, roomID)
+//		}
+//	})
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// Message types sent by Join and Leave unless overridden in Options
+const (
+	JoinType  = "room.join"
+	LeaveType = "room.leave"
+)
+
+var (
+	// ErrClosed is returned after Close
+	ErrClosed = errors.New("client closed")
+	// ErrNotConnected is returned by Send while reconnecting
+	ErrNotConnected = errors.New("not connected")
+)
+
+// Options contains client configuration
+type Options struct {
+	Header http.Header     // sent with every handshake, e.g. Authorization
+	Dialer *gorilla.Dialer // nil = gorilla.DefaultDialer
+
+	// Reconnect backoff. The delay doubles per failed attempt up to
+	// MaxBackoff, and a random half of it is jitter.
+	MinBackoff time.Duration // default 500ms
+	MaxBackoff time.Duration // default 30s
+	MaxRetries int           // failed attempts in a row before giving up, 0 = never
+
+	ReadTimeout  time.Duration // reconnect if the server sends nothing for this long, default 90s
+	WriteTimeout time.Duration // default 10s
+
+	JoinType  string // default JoinType
+	LeaveType string // default LeaveType
+
+	Logger *slog.Logger // nil = warnings and errors to stderr
+}
+
+// Client is a connection to a Hub that survives reconnects
+type Client struct {
+	url    string
+	opts   Options
+	logger *slog.Logger
+
+	conn    *gorilla.Conn
+	connMu  sync.Mutex
+	writeMu sync.Mutex
+
+	// Rooms to re-join after a reconnect, with their join data
+	rooms   map[string]map[string]interface{}
+	roomsMu sync.Mutex
+
+	handlers     map[string][]func(websocket.Message)
+	fallback     []func(websocket.Message)
+	onReconnect  []func()
+	onDisconnect []func(error)
+	handlersMu   sync.RWMutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Dial connects to a Hub. The first connection attempt is not retried, so
+// a bad URL or rejected credentials fail fast; later disconnects reconnect
+// in the background until Close.
+func Dial(ctx context.Context, url string, opts *Options) (*Client, error) {
+	c := &Client{
+		url:      url,
+		rooms:    make(map[string]map[string]interface{}),
+		handlers: make(map[string][]func(websocket.Message)),
+		done:     make(chan struct{}),
+	}
+	if opts != nil {
+		c.opts = *opts
+	}
+	c.applyDefaults()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run(conn)
+
+	return c, nil
+}
+
+func (c *Client) applyDefaults() {
+	if c.opts.Dialer == nil {
+		c.opts.Dialer = gorilla.DefaultDialer
+	}
+	if c.opts.MinBackoff <= 0 {
+		c.opts.MinBackoff = 500 * time.Millisecond
+	}
+	if c.opts.MaxBackoff <= 0 {
+		c.opts.MaxBackoff = 30 * time.Second
+	}
+	if c.opts.MaxBackoff < c.opts.MinBackoff {
+		c.opts.MaxBackoff = c.opts.MinBackoff
+	}
+	if c.opts.ReadTimeout <= 0 {
+		c.opts.ReadTimeout = 90 * time.Second
+	}
+	if c.opts.WriteTimeout <= 0 {
+		c.opts.WriteTimeout = 10 * time.Second
+	}
+	if c.opts.JoinType == "" {
+		c.opts.JoinType = JoinType
+	}
+	if c.opts.LeaveType == "" {
+		c.opts.LeaveType = LeaveType
+	}
+
+	c.logger = c.opts.Logger
+	if c.logger == nil {
+		c.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+			Level: slog.LevelWarn,
+		}))
+	}
+}
+
+// Handle registers a handler for a message type. Handlers run on the read
+// goroutine, in registration order.
+func (c *Client) Handle(msgType string, fn func(websocket.Message)) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.handlers[msgType] = append(c.handlers[msgType], fn)
+}
+
+// HandleDefault registers a handler for messages without a type handler
+func (c *Client) HandleDefault(fn func(websocket.Message)) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.fallback = append(c.fallback, fn)
+}
+
+// HandleData registers a handler receiving the Data of a message type
+// decoded into T. Messages that don't decode are logged and skipped.
+func HandleData[T any](c *Client, msgType string, fn func(T)) {
+	c.Handle(msgType, func(msg websocket.Message) {
+		var data T
+		raw, err := json.Marshal(msg.Data)
+		if err == nil {
+			err = json.Unmarshal(raw, &data)
+		}
+		if err != nil {
+			c.logger.Warn("cannot decode message data", "type", msgType, "error", err)
+			return
+		}
+		fn(data)
+	})
+}
+
+// OnReconnect registers a callback run after a reconnect, once rooms have
+// been re-joined
+func (c *Client) OnReconnect(fn func()) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+// OnDisconnect registers a callback run when the connection drops, with
+// the read error
+func (c *Client) OnDisconnect(fn func(error)) {
+	c.handlersMu.Lock()
+	defer c.handlersMu.Unlock()
+	c.onDisconnect = append(c.onDisconnect, fn)
+}
+
+// Send writes a message to the server
+func (c *Client) Send(msg websocket.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.ctx.Done():
+		return ErrClosed
+	default:
+	}
+
+	conn := c.currentConn()
+	if conn == nil {
+		return ErrNotConnected
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+	return conn.WriteJSON(msg)
+}
+
+// Join asks the server to add the client to a room and re-joins it after
+// every reconnect. Data is merged into the join message. While
+// disconnected, the join is sent once the connection is back.
+func (c *Client) Join(roomID string, data map[string]interface{}) error {
+	c.roomsMu.Lock()
+	c.rooms[roomID] = data
+	c.roomsMu.Unlock()
+
+	err := c.Send(c.joinMessage(roomID, data))
+	if errors.Is(err, ErrNotConnected) {
+		return nil
+	}
+	return err
+}
+
+// Leave asks the server to remove the client from a room
+func (c *Client) Leave(roomID string) error {
+	c.forgetRoom(roomID)
+
+	err := c.Send(websocket.Message{
+		Type: c.opts.LeaveType,
+		Data: map[string]interface{}{"room_id": roomID},
+	})
+	if errors.Is(err, ErrNotConnected) {
+		return nil
+	}
+	return err
+}
+
+// Rooms returns the rooms the client re-joins after a reconnect
+func (c *Client) Rooms() []string {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	rooms := make([]string, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		rooms = append(rooms, roomID)
+	}
+	return rooms
+}
+
+// Connected reports whether the client currently has a connection
+func (c *Client) Connected() bool {
+	return c.currentConn() != nil
+}
+
+// Done is closed once the client stops for good, after Close or when
+// MaxRetries is exhausted
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client stopped, once Done is closed
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Close closes the connection and stops reconnecting
+func (c *Client) Close() error {
+	c.cancel()
+
+	if conn := c.currentConn(); conn != nil {
+		conn.WriteControl(gorilla.CloseMessage,
+			gorilla.FormatCloseMessage(gorilla.CloseNormalClosure, ""),
+			time.Now().Add(c.opts.WriteTimeout))
+		conn.Close()
+	}
+
+	<-c.done
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context) (*gorilla.Conn, error) {
+	conn, _, err := c.opts.Dialer.DialContext(ctx, c.url, c.opts.Header)
+	return conn, err
+}
+
+func (c *Client) currentConn() *gorilla.Conn {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.conn
+}
+
+func (c *Client) setConn(conn *gorilla.Conn) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.conn = conn
+}
+
+// run reads from conn and reconnects whenever it drops
+func (c *Client) run(conn *gorilla.Conn) {
+	defer close(c.done)
+
+	for {
+		err := c.readLoop(conn)
+		conn.Close()
+		c.setConn(nil)
+
+		if c.ctx.Err() != nil {
+			c.err = ErrClosed
+			return
+		}
+		c.logger.Warn("connection lost", "error", err)
+		c.handlersMu.RLock()
+		callbacks := c.onDisconnect
+		c.handlersMu.RUnlock()
+		for _, fn := range callbacks {
+			fn(err)
+		}
+
+		conn, err = c.reconnect()
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.setConn(conn)
+		c.rejoin()
+
+		c.handlersMu.RLock()
+		reconnected := c.onReconnect
+		c.handlersMu.RUnlock()
+		for _, fn := range reconnected {
+			fn()
+		}
+	}
+}
+
+// reconnect dials until it succeeds, the client is closed or MaxRetries
+// attempts failed
+func (c *Client) reconnect() (*gorilla.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return nil, ErrClosed
+		}
+
+		conn, err := c.dial(c.ctx)
+		if err == nil {
+			c.logger.Info("reconnected", "attempts", attempt+1)
+			return conn, nil
+		}
+		if c.ctx.Err() != nil {
+			return nil, ErrClosed
+		}
+		c.logger.Warn("reconnect failed", "attempt", attempt+1, "error", err)
+
+		if c.opts.MaxRetries > 0 && attempt+1 >= c.opts.MaxRetries {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the delay before a reconnect attempt: exponential with
+// equal jitter, so clients dropped together don't reconnect together
+func (c *Client) backoff(attempt int) time.Duration {
+	delay := c.opts.MaxBackoff
+	if attempt < 30 {
+		if d := c.opts.MinBackoff << attempt; d > 0 && d < delay {
+			delay = d
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// rejoin sends a join message for every tracked room
+func (c *Client) rejoin() {
+	c.roomsMu.Lock()
+	joins := make([]websocket.Message, 0, len(c.rooms))
+	for roomID, data := range c.rooms {
+		joins = append(joins, c.joinMessage(roomID, data))
+	}
+	c.roomsMu.Unlock()
+
+	for _, msg := range joins {
+		if err := c.Send(msg); err != nil {
+			c.logger.Warn("rejoin failed", "room_id", msg.Data["room_id"], "error", err)
+		}
+	}
+}
+
+func (c *Client) joinMessage(roomID string, data map[string]interface{}) websocket.Message {
+	msg := websocket.Message{
+		Type: c.opts.JoinType,
+		Data: make(map[string]interface{}, len(data)+1),
+	}
+	for k, v := range data {
+		msg.Data[k] = v
+	}
+	msg.Data["room_id"] = roomID
+	return msg
+}
+
+func (c *Client) forgetRoom(roomID string) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	delete(c.rooms, roomID)
+}
+
+// readLoop dispatches messages until the connection fails
+func (c *Client) readLoop(conn *gorilla.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+	conn.SetPingHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+		// Echo the payload so the hub can measure latency
+		conn.WriteControl(gorilla.PongMessage, []byte(appData), time.Now().Add(c.opts.WriteTimeout))
+		return nil
+	})
+
+	for {
+		var msg websocket.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				c.logger.Warn("invalid message", "error", err)
+				continue
+			}
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(c.opts.ReadTimeout))
+
+		// Rooms the server removed us from are not re-joined
+		switch msg.Type {
+		case "room_closed", "kicked", "join_denied":
+			if roomID, ok := msg.Data["room_id"].(string); ok {
+				c.forgetRoom(roomID)
+			}
+		}
+
+		c.dispatch(msg)
+	}
+}
+
+// dispatch runs the handlers of a message
+func (c *Client) dispatch(msg websocket.Message) {
+	c.handlersMu.RLock()
+	handlers := c.handlers[msg.Type]
+	if len(handlers) == 0 {
+		handlers = c.fallback
+	}
+	c.handlersMu.RUnlock()
+
+	for _, fn := range handlers {
+		fn(msg)
+	}
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+// newServer starts a hub that routes room.join and room.leave messages
+func newServer(t *testing.T) (*websocket.Hub, *httptest.Server, string) {
+	hub := websocket.NewHub(nil)
+	go hub.Run()
+
+	hub.OnMessage(func(ctx context.Context, c *websocket.Client, msg websocket.Message) {
+		roomID, _ := msg.Data["room_id"].(string)
+		switch msg.Type {
+		case JoinType:
+			hub.JoinRoom(c.UserID, roomID)
+		case LeaveType:
+			hub.LeaveRoom(c.UserID, roomID)
+		}
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.HandleConnection(hub, w, r, r.URL.Query().Get("user_id"))
+	}))
+	t.Cleanup(server.Close)
+
+	return hub, server, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// waitFor polls cond until it holds or a second passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestReconnectRejoinsRooms(t *testing.T) {
+	hub, _, url := newServer(t)
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Lobby", Lifecycle: websocket.Persistent})
+
+	c, err := Dial(context.Background(), url+"?user_id=bot", &Options{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	type chat struct {
+		Text string `json:"text"`
+	}
+	received := make(chan string, 10)
+	HandleData(c, "chat", func(data chat) {
+		received <- data.Text
+	})
+	reconnected := make(chan struct{}, 1)
+	c.OnReconnect(func() { reconnected <- struct{}{} })
+
+	c.Join(roomID, nil)
+	waitFor(t, "join", func() bool { return hub.GetRoomClientCount(roomID) == 1 })
+
+	hub.DisconnectUser("bot")
+	select {
+	case <-reconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected client to reconnect")
+	}
+	waitFor(t, "rejoin", func() bool { return hub.GetRoomClientCount(roomID) == 1 })
+
+	hub.BroadcastToRoom(roomID, websocket.Message{Type: "chat", Data: map[string]interface{}{"text": "hello"}})
+	select {
+	case text := <-received:
+		if text != "hello" {
+			t.Errorf("Expected hello, got %q", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected typed handler to receive the broadcast")
+	}
+
+	c.Leave(roomID)
+	waitFor(t, "leave", func() bool { return hub.GetRoomClientCount(roomID) == 0 })
+	if len(c.Rooms()) != 0 {
+		t.Errorf("Expected no tracked rooms, got %v", c.Rooms())
+	}
+}
+
+func TestClosedRoomIsNotRejoined(t *testing.T) {
+	hub, _, url := newServer(t)
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Match", Lifecycle: websocket.Persistent})
+
+	c, err := Dial(context.Background(), url+"?user_id=bot", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	closed := make(chan struct{}, 1)
+	c.Handle("room_closed", func(msg websocket.Message) { closed <- struct{}{} })
+
+	c.Join(roomID, map[string]interface{}{"team": "red"})
+	waitFor(t, "join", func() bool { return hub.GetRoomClientCount(roomID) == 1 })
+
+	hub.CloseRoom(roomID)
+	<-closed
+	if len(c.Rooms()) != 0 {
+		t.Errorf("Expected closed room to be forgotten, got %v", c.Rooms())
+	}
+}
+
+func TestGiveUpAfterMaxRetries(t *testing.T) {
+	hub, server, url := newServer(t)
+
+	c, err := Dial(context.Background(), url+"?user_id=bot", &Options{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		MaxRetries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.CloseClientConnections()
+	server.Close()
+	hub.DisconnectUser("bot")
+
+	select {
+	case <-c.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected client to give up")
+	}
+	if c.Err() == nil || c.Err() == ErrClosed {
+		t.Errorf("Expected dial error, got %v", c.Err())
+	}
+	if err := c.Send(websocket.Message{Type: "chat"}); err != ErrNotConnected {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+	c.Close()
+}
+
+func TestBackoff(t *testing.T) {
+	c := &Client{opts: Options{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		max *= time.Millisecond
+		for i := 0; i < 20; i++ {
+			if d := c.backoff(attempt); d < max/2 || d > max {
+				t.Fatalf("attempt %d: expected delay in [%v, %v], got %v", attempt, max/2, max, d)
+			}
+		}
+	}
+	if d := c.backoff(100); d > time.Second {
+		t.Errorf("Expected delay capped at MaxBackoff, got %v", d)
+	}
+}
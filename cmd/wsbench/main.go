@@ -0,0 +1,50 @@
+// Command wsbench load-tests a Hub in process and reports latency, losses,
+// memory and goroutines per connection.
+//
+// Usage:
+//
+//	wsbench -clients 5000 -room-size 50 -rate 2 -duration 30s [-netpoll]
+//
+// Large runs need a raised open file limit (ulimit -n), since every
+// simulated connection uses two sockets.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+	"github.com/OkanUysal/go-websocket/bench"
+)
+
+func main() {
+	config := bench.Config{}
+	flag.IntVar(&config.Clients, "clients", 100, "simulated clients")
+	flag.IntVar(&config.RoomSize, "room-size", 10, "clients per room")
+	flag.Float64Var(&config.Rate, "rate", 1, "messages per second per client")
+	flag.DurationVar(&config.Duration, "duration", 10*time.Second, "how long to send")
+	flag.IntVar(&config.MessageSize, "size", 64, "payload bytes per message")
+	flag.DurationVar(&config.Drain, "drain", 2*time.Second, "wait for in-flight messages after sending")
+	netpoll := flag.Bool("netpoll", false, "serve connections from an epoll event loop (Linux)")
+	flag.Parse()
+
+	if *netpoll {
+		config.Hub = websocket.DefaultConfig()
+		config.Hub.Netpoll = &websocket.NetpollConfig{}
+	}
+
+	// Ctrl-C ends sending early and still prints the report
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("connecting %d clients in rooms of %d", config.Clients, config.RoomSize)
+	result, err := bench.Run(ctx, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	result.Report(os.Stdout)
+}
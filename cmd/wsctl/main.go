@@ -0,0 +1,94 @@
+// Command wsctl opens an interactive session against a Hub for debugging.
+//
+// Usage:
+//
+//	wsctl -url ws://localhost:8080/ws?user_id=debug [-H 'Authorization: Bearer ...'] [-script file]
+//
+// Commands, read from stdin or the script file, one per line:
+//
+//	send <type> [json data]    send a message
+//	join <room> [json data]    join a room (re-joined after reconnects)
+//	leave <room>               leave a room
+//	wait <type> [timeout]      wait for a message of a type (default 10s)
+//	sleep <duration>           pause, e.g. sleep 500ms
+//	rooms                      list joined rooms
+//	quit                       close the connection and exit
+//
+// Lines starting with # are comments. Incoming messages are printed with a
+// timestamp as they arrive.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/OkanUysal/go-websocket/client"
+)
+
+// headerFlags collects repeated -H flags
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h headerFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("header must be 'Name: value'")
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(val))
+	return nil
+}
+
+func main() {
+	url := flag.String("url", "ws://localhost:8080/ws", "hub WebSocket URL")
+	script := flag.String("script", "", "run commands from a file, then exit")
+	interactive := flag.Bool("i", false, "with -script, read stdin after the script")
+	compact := flag.Bool("compact", false, "print message data on one line")
+	join := flag.String("join-type", client.JoinType, "message type sent by join")
+	leave := flag.String("leave-type", client.LeaveType, "message type sent by leave")
+	header := headerFlags{}
+	flag.Var(header, "H", "handshake header 'Name: value', repeatable")
+	flag.Parse()
+
+	out := newPrinter(os.Stdout, !*compact)
+
+	c, err := client.Dial(context.Background(), *url, &client.Options{
+		Header:    http.Header(header),
+		JoinType:  *join,
+		LeaveType: *leave,
+	})
+	if err != nil {
+		log.Fatalf("connect %s: %v", *url, err)
+	}
+	defer c.Close()
+
+	s := newSession(c, out)
+	out.info("connected to %s", *url)
+
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = s.run(f, false)
+		f.Close()
+		if err != nil {
+			out.info("script failed: %v", err)
+			os.Exit(1)
+		}
+		if !*interactive {
+			return
+		}
+	}
+
+	if err := s.run(os.Stdin, true); err != nil && err != io.EOF {
+		out.info("%v", err)
+		os.Exit(1)
+	}
+}
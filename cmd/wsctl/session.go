@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+	"github.com/OkanUysal/go-websocket/client"
+)
+
+// errQuit ends a session
+var errQuit = errors.New("quit")
+
+// printer writes timestamped lines; incoming messages and command output
+// come from different goroutines
+type printer struct {
+	w      io.Writer
+	indent bool
+	mu     sync.Mutex
+}
+
+func newPrinter(w io.Writer, indent bool) *printer {
+	return &printer{w: w, indent: indent}
+}
+
+// message prints an incoming message
+func (p *printer) message(msg websocket.Message) {
+	var data []byte
+	if p.indent {
+		data, _ = json.MarshalIndent(msg.Data, "", "  ")
+	} else {
+		data, _ = json.Marshal(msg.Data)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s <- %s %s\n", timestamp(), msg.Type, data)
+}
+
+// info prints a status line
+func (p *printer) info(format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s -- %s\n", timestamp(), fmt.Sprintf(format, args...))
+}
+
+func timestamp() string {
+	return time.Now().Format("15:04:05.000")
+}
+
+// session executes commands against a client connection
+type session struct {
+	client   *client.Client
+	out      *printer
+	incoming chan websocket.Message
+}
+
+func newSession(c *client.Client, out *printer) *session {
+	s := &session{
+		client:   c,
+		out:      out,
+		incoming: make(chan websocket.Message, 1024),
+	}
+
+	c.HandleDefault(func(msg websocket.Message) {
+		out.message(msg)
+		s.push(msg)
+	})
+	c.OnDisconnect(func(err error) { out.info("disconnected: %v, reconnecting", err) })
+	c.OnReconnect(func() { out.info("reconnected") })
+
+	return s
+}
+
+// run executes commands line by line. Interactive sessions report errors
+// and continue; scripts stop at the first error.
+func (s *session) run(r io.Reader, interactive bool) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		err := s.exec(scanner.Text())
+		if err == errQuit {
+			return nil
+		}
+		if err != nil {
+			if !interactive {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			s.out.info("error: %v", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// exec runs one command
+func (s *session) exec(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	cmd, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+
+	switch cmd {
+	case "send":
+		msgType, raw, _ := strings.Cut(rest, " ")
+		if msgType == "" {
+			return errors.New("usage: send <type> [json data]")
+		}
+		data, err := parseData(raw)
+		if err != nil {
+			return err
+		}
+		return s.client.Send(websocket.Message{Type: msgType, Data: data})
+
+	case "join":
+		roomID, raw, _ := strings.Cut(rest, " ")
+		if roomID == "" {
+			return errors.New("usage: join <room> [json data]")
+		}
+		data, err := parseData(raw)
+		if err != nil {
+			return err
+		}
+		return s.client.Join(roomID, data)
+
+	case "leave":
+		if rest == "" {
+			return errors.New("usage: leave <room>")
+		}
+		return s.client.Leave(rest)
+
+	case "wait":
+		msgType, raw, _ := strings.Cut(rest, " ")
+		if msgType == "" {
+			return errors.New("usage: wait <type> [timeout]")
+		}
+		timeout := 10 * time.Second
+		if raw != "" {
+			d, err := time.ParseDuration(strings.TrimSpace(raw))
+			if err != nil {
+				return err
+			}
+			timeout = d
+		}
+		return s.wait(msgType, timeout)
+
+	case "sleep":
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return nil
+
+	case "rooms":
+		rooms := s.client.Rooms()
+		sort.Strings(rooms)
+		s.out.info("rooms: %s", strings.Join(rooms, ", "))
+		return nil
+
+	case "quit", "exit":
+		return errQuit
+
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// push queues a received message for wait. When nobody has waited for a
+// while the buffer fills up; the oldest message is dropped to make room.
+func (s *session) push(msg websocket.Message) {
+	for {
+		select {
+		case s.incoming <- msg:
+			return
+		default:
+		}
+
+		select {
+		case <-s.incoming:
+		default:
+		}
+	}
+}
+
+// wait blocks until a message of the given type arrives. Messages received
+// since the previous wait count, so a reply that beat the wait is not missed.
+func (s *session) wait(msgType string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case msg := <-s.incoming:
+			if msg.Type == msgType {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("no %s message within %v", msgType, timeout)
+		}
+	}
+}
+
+// parseData parses an optional JSON object
+func parseData(raw string) (map[string]interface{}, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return nil, fmt.Errorf("data must be a JSON object: %w", err)
+	}
+	return data, nil
+}
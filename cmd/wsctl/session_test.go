@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+	"github.com/OkanUysal/go-websocket/client"
+)
+
+func TestScript(t *testing.T) {
+	hub := websocket.NewHub(nil)
+	go hub.Run()
+
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Lobby", Lifecycle: websocket.Persistent})
+	hub.OnMessage(func(ctx context.Context, c *websocket.Client, msg websocket.Message) {
+		switch msg.Type {
+		case client.JoinType:
+			hub.JoinRoom(c.UserID, msg.Data["room_id"].(string))
+		case "ping":
+			c.SendMessage(websocket.Message{Type: "pong", Data: msg.Data})
+		}
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.HandleConnection(hub, w, r, "debug")
+	}))
+	defer server.Close()
+
+	c, err := client.Dial(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var out bytes.Buffer
+	s := newSession(c, newPrinter(&out, false))
+
+	script := `
+# join and round-trip a ping
+join ` + roomID + `
+wait room.members 2s
+send ping {"n": 1}
+wait pong 2s
+quit
+send never
+`
+	if err := s.run(strings.NewReader(script), false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `<- pong {"n":1}`) {
+		t.Errorf("Expected pong to be printed, got:\n%s", out.String())
+	}
+
+	err = s.run(strings.NewReader("send ping {bad json}\n"), false)
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected script error with line number, got %v", err)
+	}
+	if err := s.run(strings.NewReader("bogus\nquit\n"), true); err != nil {
+		t.Errorf("Expected interactive session to continue after errors, got %v", err)
+	}
+}
+
+func TestSessionDropsOldest(t *testing.T) {
+	s := &session{incoming: make(chan websocket.Message, 2)}
+	for _, msgType := range []string{"first", "second", "third"} {
+		s.push(websocket.Message{Type: msgType})
+	}
+
+	if msg := <-s.incoming; msg.Type != "second" {
+		t.Errorf("Expected the oldest message to be dropped, got %s", msg.Type)
+	}
+	if err := s.wait("third", time.Second); err != nil {
+		t.Errorf("Expected the newest message to be kept: %v", err)
+	}
+}
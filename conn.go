@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"net"
+	"time"
+)
+
+// Message types of Conn.ReadMessage and Conn.WriteMessage, the RFC 6455
+// opcodes also used by gorilla/websocket
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+)
+
+// Conn is the connection a Client reads from and writes to. A
+// *websocket.Conn from gorilla/websocket implements it as is; connections
+// from other libraries are adapted by a Transport, and the testkit package
+// has an in-memory implementation. Connections that negotiate subprotocols
+// may also implement Subprotocol() string.
+type Conn interface {
+	// ReadMessage blocks until the next data message
+	ReadMessage() (messageType int, data []byte, err error)
+	// WriteMessage writes a data, close or ping message
+	WriteMessage(messageType int, data []byte) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadLimit(limit int64)
+	// SetPongHandler sets the function called for every pong received
+	SetPongHandler(h func(appData string) error)
+	RemoteAddr() net.Addr
+	Close() error
+}
@@ -0,0 +1,294 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Unsubscribe removes a listener registered with one of the On* methods.
+// Calling it more than once is safe.
+type Unsubscribe func()
+
+// hookList holds the listeners of one event. Listeners may be added and
+// removed at any time, including from inside a listener.
+type hookList[F any] struct {
+	nextID  int
+	entries []hookEntry[F]
+	setID   int // listener installed by the SetOn* method, 0 = none
+	mu      sync.RWMutex
+}
+
+type hookEntry[F any] struct {
+	id int
+	fn F
+}
+
+// add registers a listener
+func (l *hookList[F]) add(fn F) Unsubscribe {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := l.insert(fn)
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.remove(id)
+	}
+}
+
+// replace swaps the listener installed by SetOn*, keeping the others.
+// Emitters see either the old or the new listener, never neither.
+func (l *hookList[F]) replace(fn F, isNil bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(l.setID)
+	l.setID = 0
+	if !isNil {
+		l.setID = l.insert(fn)
+	}
+}
+
+// insert appends a listener and returns its ID. Caller must hold l.mu.
+func (l *hookList[F]) insert(fn F) int {
+	l.nextID++
+	l.entries = append(l.entries, hookEntry[F]{id: l.nextID, fn: fn})
+	return l.nextID
+}
+
+// remove drops a listener by ID. Caller must hold l.mu.
+func (l *hookList[F]) remove(id int) {
+	for i, entry := range l.entries {
+		if entry.id == id {
+			// Copy so snapshots taken by running emitters stay intact
+			entries := make([]hookEntry[F], 0, len(l.entries)-1)
+			entries = append(entries, l.entries[:i]...)
+			l.entries = append(entries, l.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// snapshot returns the current listeners in registration order
+func (l *hookList[F]) snapshot() []F {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	fns := make([]F, len(l.entries))
+	for i, entry := range l.entries {
+		fns[i] = entry.fn
+	}
+	return fns
+}
+
+// hooks holds the listeners of every hub event
+type hooks struct {
+	connect     hookList[func(context.Context, *Client)]
+	disconnect  hookList[func(*Client)]
+	message     hookList[func(context.Context, *Client, Message)]
+	highLatency hookList[func(*Client, time.Duration)]
+	roomCreated hookList[func(*Room)]
+	roomClosed  hookList[func(*Room, string)]
+	beforeJoin  hookList[func(*Client, *Room) error]
+	joined      hookList[func(*Client, *Room)]
+	left        hookList[func(string, *Room)]
+	kicked      hookList[func(string, *Room, string)]
+}
+
+// OnConnect registers a listener called with the client's context when a
+// client connects
+func (h *Hub) OnConnect(fn func(context.Context, *Client)) Unsubscribe {
+	return h.hooks.connect.add(fn)
+}
+
+// OnDisconnect registers a listener called when a client disconnects
+func (h *Hub) OnDisconnect(fn func(*Client)) Unsubscribe {
+	return h.hooks.disconnect.add(fn)
+}
+
+// OnMessage registers a listener called for every application message. The
+// context is cancelled when the client disconnects and carries the handling
+// span when a Tracer is configured.
+func (h *Hub) OnMessage(fn func(context.Context, *Client, Message)) Unsubscribe {
+	return h.hooks.message.add(fn)
+}
+
+// OnHighLatency registers a listener called when a client's round-trip time
+// rises above Config.LatencyThreshold. It fires again only after the
+// latency has dropped back below the threshold.
+func (h *Hub) OnHighLatency(fn func(*Client, time.Duration)) Unsubscribe {
+	return h.hooks.highLatency.add(fn)
+}
+
+// OnRoomCreated registers a listener called when a room is created
+func (h *Hub) OnRoomCreated(fn func(*Room)) Unsubscribe {
+	return h.hooks.roomCreated.add(fn)
+}
+
+// OnRoomClosed registers a listener called with the close reason
+func (h *Hub) OnRoomClosed(fn func(*Room, string)) Unsubscribe {
+	return h.hooks.roomClosed.add(fn)
+}
+
+// OnBeforeJoin registers a listener that may veto a join by returning an
+// error; JoinRoom returns that error and the client gets a join_denied message
+func (h *Hub) OnBeforeJoin(fn func(*Client, *Room) error) Unsubscribe {
+	return h.hooks.beforeJoin.add(fn)
+}
+
+// OnJoined registers a listener called after a client joined a room
+func (h *Hub) OnJoined(fn func(*Client, *Room)) Unsubscribe {
+	return h.hooks.joined.add(fn)
+}
+
+// OnLeft registers a listener called with the user ID after a user left a
+// room, including on disconnect and kick
+func (h *Hub) OnLeft(fn func(string, *Room)) Unsubscribe {
+	return h.hooks.left.add(fn)
+}
+
+// OnKicked registers a listener called with the user ID and reason before a
+// kicked user is removed from the room
+func (h *Hub) OnKicked(fn func(string, *Room, string)) Unsubscribe {
+	return h.hooks.kicked.add(fn)
+}
+
+// SetOnConnect sets the onConnect hook, replacing the one set before.
+// Listeners added with OnConnect are kept.
+func (h *Hub) SetOnConnect(fn func(*Client)) {
+	h.hooks.connect.replace(func(ctx context.Context, client *Client) {
+		fn(client)
+	}, fn == nil)
+}
+
+// SetOnDisconnect sets the onDisconnect hook
+func (h *Hub) SetOnDisconnect(fn func(*Client)) {
+	h.hooks.disconnect.replace(fn, fn == nil)
+}
+
+// SetOnMessage sets the onMessage hook
+func (h *Hub) SetOnMessage(fn func(*Client, Message)) {
+	h.hooks.message.replace(func(ctx context.Context, client *Client, msg Message) {
+		fn(client, msg)
+	}, fn == nil)
+}
+
+// SetOnHighLatency sets the onHighLatency hook
+func (h *Hub) SetOnHighLatency(fn func(*Client, time.Duration)) {
+	h.hooks.highLatency.replace(fn, fn == nil)
+}
+
+// SetOnRoomCreated sets the onRoomCreated hook
+func (h *Hub) SetOnRoomCreated(fn func(*Room)) {
+	h.hooks.roomCreated.replace(fn, fn == nil)
+}
+
+// SetOnRoomClosed sets the onRoomClosed hook
+func (h *Hub) SetOnRoomClosed(fn func(*Room, string)) {
+	h.hooks.roomClosed.replace(fn, fn == nil)
+}
+
+// SetOnBeforeJoin sets the onBeforeJoin hook
+func (h *Hub) SetOnBeforeJoin(fn func(*Client, *Room) error) {
+	h.hooks.beforeJoin.replace(fn, fn == nil)
+}
+
+// SetOnJoined sets the onJoined hook
+func (h *Hub) SetOnJoined(fn func(*Client, *Room)) {
+	h.hooks.joined.replace(fn, fn == nil)
+}
+
+// SetOnLeft sets the onLeft hook
+func (h *Hub) SetOnLeft(fn func(string, *Room)) {
+	h.hooks.left.replace(fn, fn == nil)
+}
+
+// SetOnKicked sets the onKicked hook
+func (h *Hub) SetOnKicked(fn func(string, *Room, string)) {
+	h.hooks.kicked.replace(fn, fn == nil)
+}
+
+// callHook runs one listener, recovering from panics so a bad listener
+// cannot kill the hub loop or a client's read pump
+func (h *Hub) callHook(event string, fn func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.logger.Error("hook panicked", "event", event, "panic", fmt.Sprint(r))
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+func (h *Hub) emitConnect(client *Client) {
+	ctx := client.Context()
+	for _, fn := range h.hooks.connect.snapshot() {
+		h.callHook("connect", func() { fn(ctx, client) })
+	}
+}
+
+func (h *Hub) emitDisconnect(client *Client) {
+	for _, fn := range h.hooks.disconnect.snapshot() {
+		h.callHook("disconnect", func() { fn(client) })
+	}
+}
+
+func (h *Hub) emitMessage(ctx context.Context, client *Client, msg Message) {
+	for _, fn := range h.hooks.message.snapshot() {
+		h.callHook("message", func() { fn(ctx, client, msg) })
+	}
+}
+
+func (h *Hub) emitHighLatency(client *Client, rtt time.Duration) {
+	for _, fn := range h.hooks.highLatency.snapshot() {
+		h.callHook("high_latency", func() { fn(client, rtt) })
+	}
+}
+
+func (h *Hub) emitRoomCreated(room *Room) {
+	for _, fn := range h.hooks.roomCreated.snapshot() {
+		h.callHook("room_created", func() { fn(room) })
+	}
+}
+
+func (h *Hub) emitRoomClosed(room *Room, reason string) {
+	for _, fn := range h.hooks.roomClosed.snapshot() {
+		h.callHook("room_closed", func() { fn(room, reason) })
+	}
+}
+
+// emitBeforeJoin returns the first veto. A panicking listener vetoes the join.
+func (h *Hub) emitBeforeJoin(client *Client, room *Room) error {
+	for _, fn := range h.hooks.beforeJoin.snapshot() {
+		var err error
+		if !h.callHook("before_join", func() { err = fn(client, room) }) {
+			return errors.New("join rejected")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Hub) emitJoined(client *Client, room *Room) {
+	for _, fn := range h.hooks.joined.snapshot() {
+		h.callHook("joined", func() { fn(client, room) })
+	}
+}
+
+func (h *Hub) emitLeft(userID string, room *Room) {
+	for _, fn := range h.hooks.left.snapshot() {
+		h.callHook("left", func() { fn(userID, room) })
+	}
+}
+
+func (h *Hub) emitKicked(userID string, room *Room, reason string) {
+	for _, fn := range h.hooks.kicked.snapshot() {
+		h.callHook("kicked", func() { fn(userID, room, reason) })
+	}
+}
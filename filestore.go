@@ -0,0 +1,490 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStoreConfig contains configuration for a FileStore
+type FileStoreConfig struct {
+	Dir             string        // directory holding segment files
+	SegmentSize     int64         // rotate the active segment after this many bytes
+	MaxAge          time.Duration // drop entries older than this on compaction, 0 = keep
+	MaxSize         int64         // drop oldest entries on compaction above this size, 0 = unlimited
+	CompactInterval time.Duration // background compaction interval, 0 = disabled
+	SyncWrites      bool          // fsync after every write
+	Logger          *slog.Logger  // nil = warnings and errors to stderr
+}
+
+// DefaultFileStoreConfig returns default configuration for the given directory
+func DefaultFileStoreConfig(dir string) *FileStoreConfig {
+	return &FileStoreConfig{
+		Dir:             dir,
+		SegmentSize:     4 * 1024 * 1024, // 4MB
+		CompactInterval: 10 * time.Minute,
+	}
+}
+
+// FileStore is a MessageStore and OfflineStore backed by append-only
+// segment files in a local directory. All live entries are indexed in
+// memory; the segments make them survive process restarts.
+type FileStore struct {
+	config *FileStoreConfig
+	logger *slog.Logger
+	logs   logSet
+
+	active     *os.File
+	activeSeq  int
+	activeSize int64
+	segments   []int // sequence numbers of closed segments, oldest first
+
+	closed chan struct{}
+	mu     sync.Mutex
+}
+
+// fileRecord is one line in a segment file
+type fileRecord struct {
+	Op    string        `json:"op"`
+	Key   string        `json:"key"`
+	Entry *HistoryEntry `json:"entry,omitempty"`
+	ID    int64         `json:"id,omitempty"`
+}
+
+// Segment record operations
+const (
+	opAppend = "append" // add Entry
+	opDrop   = "drop"   // remove entries with ID < ID
+	opDelete = "delete" // remove the key
+	opSeq    = "seq"    // last assigned ID is at least ID
+)
+
+// errFileStoreClosed is returned by Compact after Close
+var errFileStoreClosed = errors.New("file store closed")
+
+const segmentPrefix = "segment-"
+const segmentSuffix = ".log"
+
+// NewFileStore opens or creates a file store, replaying existing segments
+func NewFileStore(config *FileStoreConfig) (*FileStore, error) {
+	if config == nil || config.Dir == "" {
+		return nil, errors.New("file store directory required")
+	}
+
+	// Defaults are filled into a copy, not the caller's config
+	copied := *config
+	config = &copied
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = DefaultFileStoreConfig(config.Dir).SegmentSize
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = defaultLogger()
+	}
+
+	s := &FileStore{
+		config: config,
+		logger: logger,
+		logs:   make(logSet),
+		closed: make(chan struct{}),
+	}
+
+	seqs, err := s.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	for _, seq := range seqs {
+		if err := s.replaySegment(seq); err != nil {
+			return nil, err
+		}
+	}
+	s.segments = seqs
+
+	next := 1
+	if len(seqs) > 0 {
+		next = seqs[len(seqs)-1] + 1
+	}
+	if err := s.openSegment(next); err != nil {
+		return nil, err
+	}
+
+	if config.CompactInterval > 0 {
+		go s.compactLoop()
+	}
+
+	return s, nil
+}
+
+// Append records a message for a room
+func (s *FileStore) Append(roomID string, msg Message) (HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.logs.append(roomID, msg)
+	return entry, s.write(fileRecord{Op: opAppend, Key: roomKeyPrefix + roomID, Entry: &entry})
+}
+
+// History returns a page of entries older than before
+func (s *FileStore) History(roomID string, before int64, limit int) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logs.page(roomKeyPrefix+roomID, before, limit), nil
+}
+
+// Since returns the entries newer than after
+func (s *FileStore) Since(roomID string, after int64) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logs.since(roomKeyPrefix+roomID, after), nil
+}
+
+// Trim applies retention limits to a room's history
+func (s *FileStore) Trim(roomID string, maxMessages int, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := roomKeyPrefix + roomID
+	return s.drop(key, s.logs.trimPoint(key, maxMessages, maxAge))
+}
+
+// Delete removes a room's history
+func (s *FileStore) Delete(roomID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := roomKeyPrefix + roomID
+	if _, ok := s.logs[key]; !ok {
+		return nil
+	}
+	delete(s.logs, key)
+	return s.write(fileRecord{Op: opDelete, Key: key})
+}
+
+// Enqueue stores a message for an offline user
+func (s *FileStore) Enqueue(userID, from string, msg Message) (HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.logs.enqueue(userID, from, msg)
+	return entry, s.write(fileRecord{Op: opAppend, Key: inboxKeyPrefix + userID, Entry: &entry})
+}
+
+// Pending returns the stored messages for a user
+func (s *FileStore) Pending(userID string) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logs.page(inboxKeyPrefix+userID, 0, 0), nil
+}
+
+// Ack removes delivered messages for a user
+func (s *FileStore) Ack(userID string, upTo int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.drop(inboxKeyPrefix+userID, upTo+1)
+}
+
+// TrimPending applies retention limits to a user's offline messages
+func (s *FileStore) TrimPending(userID string, maxMessages int, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := inboxKeyPrefix + userID
+	return s.drop(key, s.logs.trimPoint(key, maxMessages, maxAge))
+}
+
+// Compact rewrites all live entries into a single segment, applying
+// MaxAge and MaxSize retention, and removes the old segments
+func (s *FileStore) Compact() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return errFileStoreClosed
+	default:
+	}
+
+	s.applyRetention()
+
+	// Everything written so far, including the active segment, is replaced
+	seq := s.activeSeq + 1
+
+	tmpPath := s.segmentPath(seq) + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	for key, l := range s.logs {
+		// Old segments may survive a crash before they are removed; the
+		// drop keeps entries removed by retention from coming back
+		dropID := l.lastID + 1
+		if len(l.entries) > 0 {
+			dropID = l.entries[0].ID
+		}
+		if err := writeRecord(w, fileRecord{Op: opDrop, Key: key, ID: dropID}); err != nil {
+			f.Close()
+			return err
+		}
+		for i := range l.entries {
+			if err := writeRecord(w, fileRecord{Op: opAppend, Key: key, Entry: &l.entries[i]}); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		// Keeps IDs monotonic even when every entry of a key was dropped
+		if err := writeRecord(w, fileRecord{Op: opSeq, Key: key, ID: l.lastID}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.segmentPath(seq)); err != nil {
+		return err
+	}
+
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	for _, oldSeq := range append(s.segments, s.activeSeq) {
+		if err := os.Remove(s.segmentPath(oldSeq)); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("failed to remove segment", "segment", oldSeq, "error", err)
+		}
+	}
+
+	s.segments = []int{seq}
+	return s.openSegment(seq + 1)
+}
+
+// Close stops background compaction and closes the active segment
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return nil
+	default:
+		close(s.closed)
+	}
+	return s.active.Close()
+}
+
+// compactLoop compacts the store every CompactInterval until Close
+func (s *FileStore) compactLoop() {
+	ticker := time.NewTicker(s.config.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Compact(); err != nil && err != errFileStoreClosed {
+				s.logger.Error("file store compaction failed", "error", err)
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// applyRetention drops entries older than MaxAge and, if the store is
+// larger than MaxSize, the oldest entries across all keys. Caller must hold s.mu.
+func (s *FileStore) applyRetention() {
+	if s.config.MaxAge > 0 {
+		for key := range s.logs {
+			s.logs.dropBefore(key, s.logs.trimPoint(key, 0, s.config.MaxAge))
+		}
+	}
+
+	if s.config.MaxSize <= 0 {
+		return
+	}
+
+	type sized struct {
+		key  string
+		id   int64
+		at   time.Time
+		size int64
+	}
+	var all []sized
+	for key, l := range s.logs {
+		for _, e := range l.entries {
+			b, _ := json.Marshal(e)
+			all = append(all, sized{key: key, id: e.ID, at: e.CreatedAt, size: int64(len(b))})
+		}
+	}
+
+	// Keep the newest entries that fit into MaxSize
+	sort.Slice(all, func(i, j int) bool { return all[i].at.After(all[j].at) })
+	var total int64
+	keepFrom := make(map[string]int64)
+	for _, e := range all {
+		total += e.size
+		if total > s.config.MaxSize {
+			if e.id+1 > keepFrom[e.key] {
+				keepFrom[e.key] = e.id + 1
+			}
+		}
+	}
+	for key, id := range keepFrom {
+		s.logs.dropBefore(key, id)
+	}
+}
+
+// drop removes entries with ID < id and records it. Caller must hold s.mu.
+func (s *FileStore) drop(key string, id int64) error {
+	if id <= 0 {
+		return nil
+	}
+	s.logs.dropBefore(key, id)
+	return s.write(fileRecord{Op: opDrop, Key: key, ID: id})
+}
+
+// write appends a record to the active segment, rotating when it is full.
+// Caller must hold s.mu.
+func (s *FileStore) write(rec fileRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	n, err := s.active.Write(b)
+	s.activeSize += int64(n)
+	if err != nil {
+		return err
+	}
+	if s.config.SyncWrites {
+		if err := s.active.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if s.activeSize >= s.config.SegmentSize {
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+		s.segments = append(s.segments, s.activeSeq)
+		return s.openSegment(s.activeSeq + 1)
+	}
+	return nil
+}
+
+// openSegment opens a new active segment. Caller must hold s.mu.
+func (s *FileStore) openSegment(seq int) error {
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	s.active = f
+	s.activeSeq = seq
+	s.activeSize = info.Size()
+	return nil
+}
+
+// replaySegment applies all records of a segment to the in-memory index
+func (s *FileStore) replaySegment(seq int) error {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A torn write at the end of a segment after a crash
+			s.logger.Warn("skipping corrupt record", "segment", seq, "error", err)
+			continue
+		}
+
+		switch rec.Op {
+		case opAppend:
+			if rec.Entry != nil {
+				s.logs.insert(rec.Key, *rec.Entry)
+			}
+		case opDrop:
+			s.logs.dropBefore(rec.Key, rec.ID)
+		case opDelete:
+			delete(s.logs, rec.Key)
+		case opSeq:
+			if l := s.logs.get(rec.Key); rec.ID > l.lastID {
+				l.lastID = rec.ID
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// listSegments returns the sequence numbers of existing segments, oldest first
+func (s *FileStore) listSegments() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(s.config.Dir, segmentPrefix+"*"+segmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make([]int, 0, len(names))
+	for _, name := range names {
+		var seq int
+		base := strings.TrimSuffix(filepath.Base(name), segmentSuffix)
+		if _, err := fmt.Sscanf(base, segmentPrefix+"%d", &seq); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+// segmentPath returns the file name of a segment
+func (s *FileStore) segmentPath(seq int) string {
+	return filepath.Join(s.config.Dir, fmt.Sprintf("%s%010d%s", segmentPrefix, seq, segmentSuffix))
+}
+
+// writeRecord writes one JSON line
+func writeRecord(w *bufio.Writer, rec fileRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Frame-level protocol for connections served by the event loop, which
+// reads from the socket itself instead of through gorilla/websocket
+
+const (
+	continuationFrame = 0
+	finalBit          = 0x80
+	maskBit           = 0x80
+	maxControlPayload = 125
+	maxFrameHeader    = 10 // server frames are not masked
+)
+
+var (
+	errMessageTooLarge = errors.New("message exceeds MaxMessageSize")
+	errProtocol        = errors.New("websocket protocol error")
+)
+
+// frame is a decoded client frame
+type frame struct {
+	fin     bool
+	opcode  int
+	payload []byte
+}
+
+// parseFrame decodes the frame at the start of data and unmasks its payload
+// in place. It returns the bytes consumed, or 0 if data holds an incomplete
+// frame. Frames longer than limit are rejected before they are complete.
+func parseFrame(data []byte, limit int64) (frame, int, error) {
+	if len(data) < 2 {
+		return frame{}, 0, nil
+	}
+
+	// No extensions are negotiated, so RSV bits must be clear
+	if data[0]&0x70 != 0 {
+		return frame{}, 0, errProtocol
+	}
+	f := frame{fin: data[0]&finalBit != 0, opcode: int(data[0] & 0x0f)}
+	switch f.opcode {
+	case continuationFrame, TextMessage, BinaryMessage, CloseMessage, PingMessage, PongMessage:
+	default:
+		return frame{}, 0, errProtocol
+	}
+
+	// Clients must mask every frame
+	if data[1]&maskBit == 0 {
+		return frame{}, 0, errProtocol
+	}
+
+	length := uint64(data[1] & 0x7f)
+	pos := 2
+	switch length {
+	case 126:
+		if len(data) < 4 {
+			return frame{}, 0, nil
+		}
+		length = uint64(binary.BigEndian.Uint16(data[2:]))
+		pos = 4
+	case 127:
+		if len(data) < 10 {
+			return frame{}, 0, nil
+		}
+		length = binary.BigEndian.Uint64(data[2:])
+		pos = 10
+	}
+
+	if f.opcode >= CloseMessage && (!f.fin || length > maxControlPayload) {
+		return frame{}, 0, errProtocol
+	}
+	if limit > 0 && length > uint64(limit) {
+		return frame{}, 0, errMessageTooLarge
+	}
+	if uint64(len(data)-pos) < 4+length {
+		return frame{}, 0, nil
+	}
+
+	mask := data[pos : pos+4]
+	pos += 4
+	end := pos + int(length)
+	f.payload = data[pos:end]
+	for i := range f.payload {
+		f.payload[i] ^= mask[i&3]
+	}
+	return f, end, nil
+}
+
+// appendFrameHeader appends the header of an unfragmented server frame
+func appendFrameHeader(b []byte, opcode int, length int) []byte {
+	b = append(b, finalBit|byte(opcode))
+	switch {
+	case length <= 125:
+		b = append(b, byte(length))
+	case length <= 0xffff:
+		b = append(b, 126, byte(length>>8), byte(length))
+	default:
+		b = append(b, 127)
+		b = binary.BigEndian.AppendUint64(b, uint64(length))
+	}
+	return b
+}
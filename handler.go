@@ -1,60 +1,85 @@
 package websocket
 
 import (
+	"context"
 	"net/http"
-
-	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins - configure in production
-	},
-}
-
 // HandleConnection upgrades HTTP connection to WebSocket
 func HandleConnection(hub *Hub, w http.ResponseWriter, r *http.Request, userID string) error {
+	return handleConnection(hub, hub.transport, w, r, userID)
+}
+
+// HandleConnectionWithConfig upgrades with custom upgrader config
+func HandleConnectionWithConfig(hub *Hub, w http.ResponseWriter, r *http.Request, userID string, config *Config) error {
+	transport := config.Transport
+	if transport == nil {
+		transport = NewGorillaTransport(config)
+	}
+	return handleConnection(hub, transport, w, r, userID)
+}
+
+func handleConnection(hub *Hub, transport Transport, w http.ResponseWriter, r *http.Request, userID string) error {
 	// Upgrade connection
-	conn, err := upgrader.Upgrade(w, r, nil)
+	conn, err := transport.Upgrade(w, r)
 	if err != nil {
+		if hub.config.Metrics != nil {
+			hub.config.Metrics.UpgradeFailed(upgradeFailureReason(err))
+		}
 		return err
 	}
 
-	// Create client
-	client := NewClient(hub, conn, userID)
-
-	// Register client
-	hub.Register <- client
-
-	// Start read/write pumps
-	go client.WritePump()
-	go client.ReadPump()
+	// Create client. The request context is cancelled once this handler
+	// returns, so keep only its values.
+	client := newClient(context.WithoutCancel(r.Context()), hub, conn, userID)
+	client.handshake = newHandshake(r, conn, hub.config)
 
+	hub.serve(client)
 	return nil
 }
 
-// HandleConnectionWithConfig upgrades with custom upgrader config
-func HandleConnectionWithConfig(hub *Hub, w http.ResponseWriter, r *http.Request, userID string, config *Config) error {
-	customUpgrader := websocket.Upgrader{
-		ReadBufferSize:  config.ReadBufferSize,
-		WriteBufferSize: config.WriteBufferSize,
-		CheckOrigin: func(r *http.Request) bool {
-			return true
-		},
-	}
+// ServeConn runs a client on an established connection and returns it once
+// Run has registered it. HandleConnection does this after upgrading; call it directly
+// for connections accepted outside of a Transport or from the testkit
+// package. The client's context is derived from ctx.
+func (h *Hub) ServeConn(ctx context.Context, conn Conn, userID string) *Client {
+	client := newClient(ctx, h, conn, userID)
+	h.serve(client)
+	return client
+}
 
-	conn, err := customUpgrader.Upgrade(w, r, nil)
-	if err != nil {
-		return err
+// serve registers a client through Run and starts its read/write pumps,
+// or hands it to the poller in netpoll mode
+func (h *Hub) serve(client *Client) {
+	if h.poller != nil {
+		if start := h.poller.watch(client); start != nil {
+			if h.register(client) {
+				start()
+			}
+			return
+		}
 	}
 
-	client := NewClient(hub, conn, userID)
-	hub.Register <- client
+	if !h.register(client) {
+		return
+	}
 
 	go client.WritePump()
 	go client.ReadPump()
+}
 
-	return nil
+// register sends a client to Run and waits until it is registered. A
+// client of a closed hub is disconnected instead and false is returned.
+func (h *Hub) register(client *Client) bool {
+	select {
+	case h.Register <- client:
+		<-client.registered
+		return true
+	case <-h.done:
+		client.cancel()
+		if client.Conn != nil {
+			client.Conn.Close()
+		}
+		return false
+	}
 }
@@ -0,0 +1,126 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Handshake holds details of the HTTP upgrade request of a connection
+type Handshake struct {
+	RemoteAddr  string // address of the peer, usually ip:port
+	RemoteIP    string // client IP, from proxy headers if Config.TrustProxyHeaders
+	Host        string
+	Path        string
+	Header      http.Header
+	Query       url.Values
+	Cookies     []*http.Cookie
+	TLS         *tls.ConnectionState // nil for plain connections
+	Subprotocol string               // negotiated subprotocol, if any
+}
+
+// newHandshake captures the upgrade request of a connection
+func newHandshake(r *http.Request, conn Conn, config *Config) *Handshake {
+	hs := &Handshake{
+		RemoteAddr: r.RemoteAddr,
+		RemoteIP:   remoteIP(r, config.TrustProxyHeaders),
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Header:     r.Header.Clone(),
+		Query:      r.URL.Query(),
+		Cookies:    r.Cookies(),
+	}
+	if r.TLS != nil {
+		state := *r.TLS
+		hs.TLS = &state
+	}
+	if conn, ok := conn.(subprotocoler); ok {
+		hs.Subprotocol = conn.Subprotocol()
+	}
+	return hs
+}
+
+// Handshake returns a copy of the upgrade request details. It is empty for
+// clients not created by HandleConnection.
+func (c *Client) Handshake() Handshake {
+	if c.handshake == nil {
+		return Handshake{}
+	}
+
+	hs := *c.handshake
+	hs.Header = hs.Header.Clone()
+	hs.Query = make(url.Values, len(c.handshake.Query))
+	for k, v := range c.handshake.Query {
+		hs.Query[k] = append([]string(nil), v...)
+	}
+	hs.Cookies = make([]*http.Cookie, len(c.handshake.Cookies))
+	for i, cookie := range c.handshake.Cookies {
+		copied := *cookie
+		hs.Cookies[i] = &copied
+	}
+	return hs
+}
+
+// RemoteIP returns the client IP captured at the handshake
+func (c *Client) RemoteIP() string {
+	if c.handshake == nil {
+		return ""
+	}
+	return c.handshake.RemoteIP
+}
+
+// Header returns a request header value from the handshake
+func (c *Client) Header(key string) string {
+	if c.handshake == nil {
+		return ""
+	}
+	return c.handshake.Header.Get(key)
+}
+
+// Query returns a query parameter from the handshake URL
+func (c *Client) Query(key string) string {
+	if c.handshake == nil {
+		return ""
+	}
+	return c.handshake.Query.Get(key)
+}
+
+// Cookie returns a cookie sent with the handshake, or nil
+func (c *Client) Cookie(name string) *http.Cookie {
+	if c.handshake == nil {
+		return nil
+	}
+	for _, cookie := range c.handshake.Cookies {
+		if cookie.Name == name {
+			copied := *cookie
+			return &copied
+		}
+	}
+	return nil
+}
+
+// remoteIP returns the client IP of a request. Proxy headers are only
+// honoured when trusted, since clients can set them freely.
+func remoteIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		// Clients can send their own X-Forwarded-For; only the last entry,
+		// appended by the trusted proxy, can't be spoofed
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if last := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:]); last != "" {
+				return last
+			}
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"errors"
+)
+
+// GetRoomHistory returns up to limit messages older than the message ID
+// before (0 = latest), oldest first
+func (h *Hub) GetRoomHistory(roomID string, before int64, limit int) ([]HistoryEntry, error) {
+	room := h.GetRoom(roomID)
+	if room != nil {
+		if room.History == nil {
+			return nil, errors.New("room history not enabled")
+		}
+		// Drop expired entries before reading
+		h.store.Trim(roomID, room.History.MaxMessages, room.History.MaxAge)
+	}
+
+	return h.store.History(roomID, before, limit)
+}
+
+// recordHistory appends a message to the room's history, returning the
+// message with its history ID set
+func (h *Hub) recordHistory(room *Room, msg Message) Message {
+	if room.History == nil {
+		return msg
+	}
+
+	// Trace context belongs to this delivery, not to the stored message
+	stored := msg
+	stored.Trace = nil
+
+	entry, err := h.store.Append(room.ID, stored)
+	if err != nil {
+		h.logger.Error("failed to record history", "room_id", room.ID, "error", err)
+		return msg
+	}
+
+	if room.History.MaxMessages > 0 || room.History.MaxAge > 0 {
+		if err := h.store.Trim(room.ID, room.History.MaxMessages, room.History.MaxAge); err != nil {
+			h.logger.Warn("failed to trim history", "room_id", room.ID, "error", err)
+		}
+	}
+
+	entry.Message.Trace = msg.Trace
+	return entry.Message
+}
+
+// replayHistory sends the most recent messages of a room to a new member
+func (h *Hub) replayHistory(client *Client, room *Room) {
+	if room.History == nil || room.History.ReplayOnJoin <= 0 {
+		return
+	}
+
+	entries, err := h.GetRoomHistory(room.ID, 0, room.History.ReplayOnJoin)
+	if err != nil {
+		h.logger.Error("failed to load history", "room_id", room.ID, "error", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	client.SendMessage(Message{
+		Type: "room_history",
+		Data: map[string]interface{}{
+			"room_id":  room.ID,
+			"messages": entries,
+		},
+	})
+}
@@ -1,37 +1,57 @@
 package websocket
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
-	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Hub manages WebSocket connections and rooms
 type Hub struct {
-	config *Config
+	config    *Config
+	logger    *slog.Logger
+	clock     Clock
+	transport Transport
+	poller    *poller // nil unless in netpoll mode
 
-	// Client management
-	clients   map[string]*Client
-	clientsMu sync.RWMutex
-
-	// Room management
-	rooms   map[string]*Room
-	roomsMu sync.RWMutex
+	// Clients by user ID and rooms by room ID, each split into shards
+	// with their own lock
+	clientShards []*clientShard
+	roomShards   []*roomShard
 
 	// Channels
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan Message
 
+	// Closed by Close, which stops Run
+	done      chan struct{}
+	closeOnce sync.Once
+
 	// Optional cache (go-cache)
 	cache interface{}
 
-	// Middleware hooks
-	onConnect    func(*Client)
-	onDisconnect func(*Client)
-	onMessage    func(*Client, Message)
+	// Room history storage and read cursors
+	store   MessageStore
+	cursors *readCursors
+
+	// Offline message queue, nil when disabled
+	offline   OfflineStore
+	offlineMu sync.Mutex
+
+	// Presence tracking, nil when disabled
+	presence *presenceTracker
+
+	// Middleware and room lifecycle hooks
+	hooks hooks
 }
 
 // NewHub creates a new WebSocket hub
@@ -40,18 +60,101 @@ func NewHub(config *Config) *Hub {
 		config = DefaultConfig()
 	}
 
-	return &Hub{
-		config:     config,
-		clients:    make(map[string]*Client),
-		rooms:      make(map[string]*Room),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
-		Broadcast:  make(chan Message),
-		cache:      config.Cache,
+	// Defaults are filled into a copy, not the caller's config
+	copied := *config
+	config = &copied
+
+	store := config.MessageStore
+	if store == nil {
+		store = NewMemoryStore()
+	}
+
+	var offline OfflineStore
+	if config.Offline != nil {
+		offline = config.Offline.Store
+		if offline == nil {
+			if s, ok := store.(OfflineStore); ok {
+				offline = s
+			} else {
+				offline = NewMemoryStore()
+			}
+		}
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = defaultLogger()
+	}
+
+	clock := config.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	transport := config.Transport
+	if transport == nil {
+		gorilla := NewGorillaTransport(config)
+		if config.Netpoll != nil {
+			// Polled connections never write through gorilla, so don't
+			// allocate a write buffer per connection
+			gorilla.Upgrader.WriteBufferPool = &sync.Pool{}
+		}
+		transport = gorilla
+	}
+
+	// Connection timings left zero in a partial config get the defaults
+	defaults := DefaultConfig()
+	if config.PingInterval <= 0 {
+		config.PingInterval = defaults.PingInterval
 	}
+	if config.PongWait <= 0 {
+		config.PongWait = defaults.PongWait
+	}
+	if config.WriteWait <= 0 {
+		config.WriteWait = defaults.WriteWait
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = defaults.MaxMessageSize
+	}
+
+	var presence *presenceTracker
+	if config.Presence != nil {
+		presence = newPresenceTracker(config.Presence)
+	}
+
+	clientShards, roomShards := newShards(config.Shards)
+
+	h := &Hub{
+		config:       config,
+		logger:       logger,
+		clock:        clock,
+		transport:    transport,
+		clientShards: clientShards,
+		roomShards:   roomShards,
+		Register:     make(chan *Client),
+		Unregister:   make(chan *Client),
+		Broadcast:    make(chan Message),
+		done:         make(chan struct{}),
+		cache:        config.Cache,
+		store:        store,
+		cursors:      newReadCursors(),
+		offline:      offline,
+		presence:     presence,
+	}
+
+	if config.Netpoll != nil {
+		p, err := newPoller(h, config.Netpoll)
+		if err != nil {
+			logger.Warn("netpoll mode unavailable, using goroutines per connection", "error", err)
+		} else {
+			h.poller = p
+		}
+	}
+
+	return h
 }
 
-// Run starts the hub's main loop
+// Run starts the hub's main loop. It returns once the hub is closed.
 func (h *Hub) Run() {
 	for {
 		select {
@@ -63,23 +166,71 @@ func (h *Hub) Run() {
 
 		case message := <-h.Broadcast:
 			h.broadcastMessage(message)
+
+		case <-h.done:
+			return
 		}
 	}
 }
 
+// Close disconnects all clients and stops Run and, in netpoll mode, the
+// poller. Connections served afterwards are closed right away.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+
+		if h.poller != nil {
+			h.poller.close()
+		}
+
+		var clients []*Client
+		h.forEachClient(func(client *Client) {
+			clients = append(clients, client)
+		})
+		for _, client := range clients {
+			h.unregisterClient(client)
+		}
+	})
+}
+
 // registerClient registers a new client
 func (h *Hub) registerClient(client *Client) {
-	h.clientsMu.Lock()
-	h.clients[client.UserID] = client
-	h.clientsMu.Unlock()
+	if h.offline != nil {
+		// Queued messages go out before anything sent after registration
+		h.offlineMu.Lock()
+		delivered := h.flushOffline(client)
+		h.addClient(client)
+		h.offlineMu.Unlock()
+
+		// Tell connected senders their messages arrived
+		for _, entry := range delivered {
+			if entry.From != "" {
+				h.notifyDelivery(entry.From, client.UserID, entry, DeliveryDelivered)
+			}
+		}
+	} else {
+		h.addClient(client)
+	}
+
+	// Close may have listed the clients before this one was added
+	select {
+	case <-h.done:
+		h.unregisterClient(client)
+		close(client.registered)
+		return
+	default:
+	}
 
-	log.Printf("Client connected: %s", client.UserID)
+	h.logger.Info("client connected", "user_id", client.UserID, "client_id", client.ID)
 
-	// Call onConnect hook
-	if h.onConnect != nil {
-		h.onConnect(client)
+	if h.presence != nil {
+		h.presenceConnected(client.UserID)
 	}
 
+	// Call onConnect hooks
+	h.emitConnect(client)
+	close(client.registered)
+
 	// Update cache if available
 	if h.cache != nil {
 		// Increment online count (assuming go-cache interface)
@@ -89,23 +240,37 @@ func (h *Hub) registerClient(client *Client) {
 
 // unregisterClient removes a client and cleans up
 func (h *Hub) unregisterClient(client *Client) {
-	h.clientsMu.Lock()
-	if _, ok := h.clients[client.UserID]; ok {
-		delete(h.clients, client.UserID)
-		close(client.Send)
+	// Stop work started on behalf of the connection
+	client.cancel()
+
+	// Only the registered connection of a user is removed; a stale one of a
+	// user who reconnected must not take the new connection down
+	shard := h.clientShard(client.UserID)
+	shard.mu.Lock()
+	registered := shard.clients[client.UserID] == client
+	if registered {
+		delete(shard.clients, client.UserID)
+	}
+	shard.mu.Unlock()
+	client.closeSend()
+
+	// Already unregistered, e.g. by DisconnectUser before ReadPump exits
+	if !registered {
+		return
 	}
-	h.clientsMu.Unlock()
 
 	// Remove from all rooms
-	h.LeaveAllRooms(client.UserID)
+	h.leaveAllRooms(client)
 
-	log.Printf("Client disconnected: %s", client.UserID)
+	h.logger.Info("client disconnected", "user_id", client.UserID, "client_id", client.ID)
 
-	// Call onDisconnect hook
-	if h.onDisconnect != nil {
-		h.onDisconnect(client)
+	if h.presence != nil {
+		h.presenceDisconnected(client.UserID)
 	}
 
+	// Call onDisconnect hooks
+	h.emitDisconnect(client)
+
 	// Update cache if available
 	if h.cache != nil {
 		// Decrement online count
@@ -113,85 +278,160 @@ func (h *Hub) unregisterClient(client *Client) {
 	}
 }
 
+// parallelBroadcastMin is the number of connected clients from which
+// BroadcastToAll sends to shards in parallel
+const parallelBroadcastMin = 1024
+
 // broadcastMessage sends message to all connected clients
 func (h *Hub) broadcastMessage(message Message) {
-	h.clientsMu.RLock()
-	defer h.clientsMu.RUnlock()
+	start := time.Now()
+	_, span := h.startSpan(context.Background(), &message, "websocket.broadcast", map[string]string{
+		"type": message.Type,
+	})
+
+	// Large broadcasts are sent by up to GOMAXPROCS goroutines, each
+	// taking the next shard; small ones aren't worth the goroutines
+	var recipients atomic.Int64
+	sendShards := func(next *atomic.Int64) {
+		for i := next.Add(1) - 1; i < int64(len(h.clientShards)); i = next.Add(1) - 1 {
+			shard := h.clientShards[i]
+			shard.mu.RLock()
+			for _, client := range shard.clients {
+				client.SendMessage(message)
+			}
+			recipients.Add(int64(len(shard.clients)))
+			shard.mu.RUnlock()
+		}
+	}
 
-	for _, client := range h.clients {
-		client.SendMessage(message)
+	var next atomic.Int64
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(h.clientShards) {
+		workers = len(h.clientShards)
+	}
+	if workers <= 1 || h.GetOnlineCount() < parallelBroadcastMin {
+		sendShards(&next)
+	} else {
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sendShards(&next)
+			}()
+		}
+		wg.Wait()
+	}
+
+	if h.config.Metrics != nil {
+		h.config.Metrics.BroadcastCompleted(int(recipients.Load()), time.Since(start))
+	}
+	if span != nil {
+		span.SetAttribute("recipients", strconv.FormatInt(recipients.Load(), 10))
+		span.End()
 	}
 }
 
 // BroadcastToAll broadcasts a message to all connected clients
 func (h *Hub) BroadcastToAll(msg Message) {
-	h.Broadcast <- msg
+	select {
+	case h.Broadcast <- msg:
+	case <-h.done:
+	}
 }
 
-// SendToUser sends a message to a specific user
+// SendToUser sends a message to a specific user. When offline queueing is
+// enabled, messages for disconnected users are stored instead of failing.
 func (h *Hub) SendToUser(userID string, msg Message) error {
-	h.clientsMu.RLock()
-	client, ok := h.clients[userID]
-	h.clientsMu.RUnlock()
+	_, err := h.SendToUserWithStatus(userID, msg)
+	return err
+}
+
+// GetClient returns a client by user ID
+func (h *Hub) GetClient(userID string) *Client {
+	client, _ := h.findClient(userID)
+	return client
+}
 
-	if !ok {
+// DisconnectUser closes a user's connection and removes it from all rooms
+func (h *Hub) DisconnectUser(userID string) error {
+	client := h.GetClient(userID)
+	if client == nil {
 		return errors.New("user not connected")
 	}
 
-	client.SendMessage(msg)
+	// Closing Send makes WritePump send a close frame and close the
+	// connection. Not through Run, which may be calling the hook that
+	// called us.
+	go h.unregisterClient(client)
 	return nil
 }
 
-// GetClient returns a client by user ID
-func (h *Hub) GetClient(userID string) *Client {
-	h.clientsMu.RLock()
-	defer h.clientsMu.RUnlock()
-	return h.clients[userID]
-}
-
 // GetOnlineCount returns the number of connected clients
 func (h *Hub) GetOnlineCount() int {
-	h.clientsMu.RLock()
-	defer h.clientsMu.RUnlock()
-	return len(h.clients)
+	count := 0
+	for _, shard := range h.clientShards {
+		shard.mu.RLock()
+		count += len(shard.clients)
+		shard.mu.RUnlock()
+	}
+	return count
 }
 
 // GetOnlineUsers returns list of connected user IDs
 func (h *Hub) GetOnlineUsers() []string {
-	h.clientsMu.RLock()
-	defer h.clientsMu.RUnlock()
-
-	users := make([]string, 0, len(h.clients))
-	for userID := range h.clients {
-		users = append(users, userID)
-	}
+	users := make([]string, 0)
+	h.forEachClient(func(client *Client) {
+		users = append(users, client.UserID)
+	})
 	return users
 }
 
-// HandleMessage processes incoming messages
+// HandleMessage processes incoming messages. The built-in presence.*,
+// room.update_member, room.signal and room.read messages are handled by the
+// hub and not passed to the onMessage hook; everything else, including
+// room.join and room.leave, is.
+// The hook gets the client's context; with a Tracer, both the context and
+// msg.Trace carry the handling span.
 func (h *Hub) HandleMessage(client *Client, msg Message) {
-	// Call onMessage hook
-	if h.onMessage != nil {
-		h.onMessage(client, msg)
+	ctx, span := h.startSpan(client.Context(), &msg, "websocket.message", map[string]string{
+		"type":      msg.Type,
+		"user_id":   client.UserID,
+		"client_id": client.ID,
+	})
+	if span != nil {
+		defer span.End()
 	}
 
-	// Default message handling can be added here
-	log.Printf("Message from %s: type=%s", client.UserID, msg.Type)
-}
+	switch msg.Type {
+	case "presence.set", "presence.subscribe", "presence.unsubscribe":
+		if h.presence != nil {
+			h.handlePresenceMessage(client, msg)
+			return
+		}
+	case "room.update_member":
+		h.handleMemberUpdate(client, msg)
+		return
+	case "room.signal":
+		h.handleSignalMessage(client, msg)
+		return
+	case "room.read":
+		h.handleReadMessage(client, msg)
+		return
+	}
 
-// SetOnConnect sets the onConnect hook
-func (h *Hub) SetOnConnect(fn func(*Client)) {
-	h.onConnect = fn
-}
+	// Call onMessage hooks
+	h.emitMessage(ctx, client, msg)
 
-// SetOnDisconnect sets the onDisconnect hook
-func (h *Hub) SetOnDisconnect(fn func(*Client)) {
-	h.onDisconnect = fn
+	// Default message handling can be added here
+	h.logger.Debug("message received", "user_id", client.UserID, "client_id", client.ID, "type", msg.Type)
 }
 
-// SetOnMessage sets the onMessage hook
-func (h *Hub) SetOnMessage(fn func(*Client, Message)) {
-	h.onMessage = fn
+// defaultLogger logs warnings and errors to stderr
+func defaultLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+		Level: slog.LevelWarn,
+	}))
 }
 
 // generateID generates a random ID
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LatencyStats summarizes the round-trip times of connected clients
+type LatencyStats struct {
+	Clients int           `json:"clients"` // clients with at least one measurement
+	P50     time.Duration `json:"p50"`
+	P90     time.Duration `json:"p90"`
+	P99     time.Duration `json:"p99"`
+	Max     time.Duration `json:"max"`
+}
+
+// Latency returns the round-trip time measured by the last ping/pong, or 0
+// before the first pong
+func (c *Client) Latency() time.Duration {
+	c.latencyMu.Lock()
+	defer c.latencyMu.Unlock()
+	return c.latency
+}
+
+// pingPayload returns the timestamp sent with a ping
+func pingPayload(now time.Time) []byte {
+	return []byte(strconv.FormatInt(now.UnixNano(), 10))
+}
+
+// recordPong measures the round trip from the timestamp echoed in a pong
+// and notifies the onHighLatency hook when the threshold is crossed
+func (c *Client) recordPong(appData string) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return
+	}
+	rtt := c.Hub.clock.Now().Sub(time.Unix(0, sent))
+	if rtt < 0 {
+		return
+	}
+
+	threshold := c.Hub.config.LatencyThreshold
+
+	c.latencyMu.Lock()
+	c.latency = rtt
+	crossed := threshold > 0 && rtt > threshold && !c.highLatency
+	if threshold > 0 {
+		c.highLatency = rtt > threshold
+	}
+	c.latencyMu.Unlock()
+
+	if crossed {
+		c.Hub.emitHighLatency(c, rtt)
+	}
+}
+
+// GetLatencyStats returns round-trip percentiles across connected clients
+func (h *Hub) GetLatencyStats() LatencyStats {
+	var samples []time.Duration
+	h.forEachClient(func(client *Client) {
+		if rtt := client.Latency(); rtt > 0 {
+			samples = append(samples, rtt)
+		}
+	})
+
+	stats := LatencyStats{Clients: len(samples)}
+	if len(samples) == 0 {
+		return stats
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	stats.P50 = percentile(samples, 0.50)
+	stats.P90 = percentile(samples, 0.90)
+	stats.P99 = percentile(samples, 0.99)
+	stats.Max = samples[len(samples)-1]
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// RoomMember is a user's per-room presence and metadata
+type RoomMember struct {
+	UserID      string                 `json:"user_id"`
+	DisplayName string                 `json:"display_name,omitempty"`
+	Avatar      string                 `json:"avatar,omitempty"`
+	Team        string                 `json:"team,omitempty"`
+	Ready       bool                   `json:"ready"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	JoinedAt    time.Time              `json:"joined_at"`
+}
+
+// GetRoomMembers returns the members of a room with their metadata
+func (h *Hub) GetRoomMembers(roomID string) []RoomMember {
+	room := h.GetRoom(roomID)
+	if room == nil {
+		return []RoomMember{}
+	}
+	return room.memberList()
+}
+
+// GetRoomMember returns one member of a room
+func (h *Hub) GetRoomMember(roomID, userID string) (RoomMember, bool) {
+	room := h.GetRoom(roomID)
+	if room == nil {
+		return RoomMember{}, false
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	member, ok := room.members[userID]
+	if !ok {
+		return RoomMember{}, false
+	}
+	return member.copy(), true
+}
+
+// UpdateRoomMember changes a member's metadata and broadcasts the changed
+// fields to the room as room.member_updated
+func (h *Hub) UpdateRoomMember(roomID, userID string, update func(*RoomMember)) error {
+	room := h.GetRoom(roomID)
+	if room == nil {
+		return errors.New("room not found")
+	}
+
+	room.mu.Lock()
+	member, ok := room.members[userID]
+	if !ok {
+		room.mu.Unlock()
+		return errors.New("user not in room")
+	}
+
+	before := member.copy()
+	update(member)
+	// Identity fields are owned by the hub
+	member.UserID = before.UserID
+	member.JoinedAt = before.JoinedAt
+	changes := memberDiff(before, *member)
+	room.mu.Unlock()
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	h.broadcastToRoom(room, Message{
+		Type: "room.member_updated",
+		Data: map[string]interface{}{
+			"room_id": roomID,
+			"user_id": userID,
+			"changes": changes,
+		},
+	})
+	return nil
+}
+
+// handleMemberUpdate applies a room.update_member message from a client
+func (h *Hub) handleMemberUpdate(client *Client, msg Message) {
+	roomID, _ := msg.Data["room_id"].(string)
+
+	err := h.UpdateRoomMember(roomID, client.UserID, func(m *RoomMember) {
+		if v, ok := msg.Data["display_name"].(string); ok {
+			m.DisplayName = v
+		}
+		if v, ok := msg.Data["avatar"].(string); ok {
+			m.Avatar = v
+		}
+		if v, ok := msg.Data["team"].(string); ok {
+			m.Team = v
+		}
+		if v, ok := msg.Data["ready"].(bool); ok {
+			m.Ready = v
+		}
+		if v, ok := msg.Data["metadata"].(map[string]interface{}); ok {
+			m.Metadata = v
+		}
+	})
+	if err != nil {
+		client.SendMessage(errorMessage(msg.Type, err))
+	}
+}
+
+// memberList returns a snapshot of the room's members ordered by join time
+func (r *Room) memberList() []RoomMember {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	members := make([]RoomMember, 0, len(r.members))
+	for _, member := range r.members {
+		members = append(members, member.copy())
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].JoinedAt.Before(members[j].JoinedAt)
+	})
+	return members
+}
+
+// copy returns a copy that does not share the metadata map
+func (m *RoomMember) copy() RoomMember {
+	c := *m
+	if m.Metadata != nil {
+		c.Metadata = make(map[string]interface{}, len(m.Metadata))
+		for k, v := range m.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return c
+}
+
+// memberDiff returns the JSON fields that differ between two members
+func memberDiff(before, after RoomMember) map[string]interface{} {
+	changes := make(map[string]interface{})
+	if before.DisplayName != after.DisplayName {
+		changes["display_name"] = after.DisplayName
+	}
+	if before.Avatar != after.Avatar {
+		changes["avatar"] = after.Avatar
+	}
+	if before.Team != after.Team {
+		changes["team"] = after.Team
+	}
+	if before.Ready != after.Ready {
+		changes["ready"] = after.Ready
+	}
+	if !reflect.DeepEqual(before.Metadata, after.Metadata) {
+		changes["metadata"] = after.Metadata
+	}
+	return changes
+}
@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Metrics receives instrumentation events from the hub. See the metrics
+// subpackage for a Prometheus exporter.
+type Metrics interface {
+	MessageReceived(msgType string, bytes int)
+	MessageSent(msgType string, bytes int)
+	MessageDropped(msgType string)
+	BroadcastCompleted(recipients int, duration time.Duration)
+	UpgradeFailed(reason string)
+}
+
+// GetRoomCount returns the number of rooms, including private ones
+func (h *Hub) GetRoomCount() int {
+	count := 0
+	for _, shard := range h.roomShards {
+		shard.mu.RLock()
+		count += len(shard.rooms)
+		shard.mu.RUnlock()
+	}
+	return count
+}
+
+// upgradeFailureReason classifies an Upgrade error for metrics
+func upgradeFailureReason(err error) string {
+	var handshakeErr websocket.HandshakeError
+	if !errors.As(err, &handshakeErr) {
+		return "other"
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "method is not GET"):
+		return "bad_method"
+	case strings.Contains(msg, "origin not allowed"):
+		return "bad_origin"
+	case strings.Contains(msg, "unsupported version"):
+		return "bad_version"
+	case strings.Contains(msg, "Sec-WebSocket-Key"):
+		return "bad_key"
+	case strings.Contains(msg, "not using the websocket protocol"):
+		return "not_websocket"
+	}
+	return "bad_handshake"
+}
@@ -0,0 +1,214 @@
+// Package metrics exports Hub instrumentation in the Prometheus text format
+// without depending on the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+// otherType is the label used once MaxTypes distinct message types are seen
+const otherType = "other"
+
+// DefaultBuckets are the broadcast latency histogram buckets in seconds
+var DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// Config contains collector configuration
+type Config struct {
+	Namespace string    // metric name prefix, default "websocket"
+	MaxTypes  int       // distinct message type labels before grouping as "other", default 100
+	Buckets   []float64 // broadcast latency buckets in seconds
+}
+
+// Collector implements websocket.Metrics and serves the collected values
+type Collector struct {
+	config *Config
+
+	received      map[string]uint64
+	sent          map[string]uint64
+	dropped       map[string]uint64
+	upgradeFailed map[string]uint64
+	bytesIn       uint64
+	bytesOut      uint64
+
+	broadcasts     uint64
+	recipients     uint64
+	latencySum     float64
+	latencyBuckets []uint64
+	knownTypes     map[string]bool
+	mu             sync.Mutex
+}
+
+// NewCollector creates a collector. Pass it as websocket.Config.Metrics.
+func NewCollector(config *Config) *Collector {
+	if config == nil {
+		config = &Config{}
+	}
+
+	// Defaults are filled into a copy, not the caller's config
+	copied := *config
+	config = &copied
+	if config.Namespace == "" {
+		config.Namespace = "websocket"
+	}
+	if config.MaxTypes <= 0 {
+		config.MaxTypes = 100
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = append([]float64(nil), DefaultBuckets...)
+	}
+
+	return &Collector{
+		config:         config,
+		received:       make(map[string]uint64),
+		sent:           make(map[string]uint64),
+		dropped:        make(map[string]uint64),
+		upgradeFailed:  make(map[string]uint64),
+		latencyBuckets: make([]uint64, len(config.Buckets)),
+		knownTypes:     make(map[string]bool),
+	}
+}
+
+// MessageReceived counts an inbound message
+func (c *Collector) MessageReceived(msgType string, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.received[c.typeLabel(msgType)]++
+	c.bytesIn += uint64(bytes)
+}
+
+// MessageSent counts an outbound message
+func (c *Collector) MessageSent(msgType string, bytes int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent[c.typeLabel(msgType)]++
+	c.bytesOut += uint64(bytes)
+}
+
+// MessageDropped counts a message that could not be queued for a client
+func (c *Collector) MessageDropped(msgType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped[c.typeLabel(msgType)]++
+}
+
+// BroadcastCompleted records the fan-out latency of a broadcast
+func (c *Collector) BroadcastCompleted(recipients int, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	seconds := duration.Seconds()
+	c.broadcasts++
+	c.recipients += uint64(recipients)
+	c.latencySum += seconds
+	for i, bound := range c.config.Buckets {
+		if seconds <= bound {
+			c.latencyBuckets[i]++
+		}
+	}
+}
+
+// UpgradeFailed counts a failed WebSocket handshake
+func (c *Collector) UpgradeFailed(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.upgradeFailed[reason]++
+}
+
+// Handler returns an http.Handler serving the metrics of the hub
+func (c *Collector) Handler(hub *websocket.Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		c.WriteMetrics(w, hub)
+	})
+}
+
+// WriteMetrics writes all metrics in the Prometheus text format
+func (c *Collector) WriteMetrics(w io.Writer, hub *websocket.Hub) {
+	ns := c.config.Namespace
+
+	if hub != nil {
+		writeHeader(w, ns+"_connected_clients", "gauge", "Number of connected clients.")
+		fmt.Fprintf(w, "%s_connected_clients %d\n", ns, hub.GetOnlineCount())
+		writeHeader(w, ns+"_rooms", "gauge", "Number of open rooms.")
+		fmt.Fprintf(w, "%s_rooms %d\n", ns, hub.GetRoomCount())
+
+		stats := hub.GetLatencyStats()
+		writeHeader(w, ns+"_client_latency_seconds", "gauge", "Round-trip time percentiles across connected clients.")
+		fmt.Fprintf(w, "%s_client_latency_seconds{quantile=\"0.5\"} %g\n", ns, stats.P50.Seconds())
+		fmt.Fprintf(w, "%s_client_latency_seconds{quantile=\"0.9\"} %g\n", ns, stats.P90.Seconds())
+		fmt.Fprintf(w, "%s_client_latency_seconds{quantile=\"0.99\"} %g\n", ns, stats.P99.Seconds())
+		fmt.Fprintf(w, "%s_client_latency_seconds{quantile=\"1\"} %g\n", ns, stats.Max.Seconds())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeCounterVec(w, ns+"_messages_received_total", "Messages received from clients by type.", "type", c.received)
+	writeCounterVec(w, ns+"_messages_sent_total", "Messages written to clients by type.", "type", c.sent)
+	writeCounterVec(w, ns+"_messages_dropped_total", "Messages dropped because a client send buffer was full or closed.", "type", c.dropped)
+	writeCounterVec(w, ns+"_upgrade_failures_total", "Failed WebSocket upgrades by reason.", "reason", c.upgradeFailed)
+
+	writeHeader(w, ns+"_received_bytes_total", "counter", "Bytes received from clients.")
+	fmt.Fprintf(w, "%s_received_bytes_total %d\n", ns, c.bytesIn)
+	writeHeader(w, ns+"_sent_bytes_total", "counter", "Bytes written to clients.")
+	fmt.Fprintf(w, "%s_sent_bytes_total %d\n", ns, c.bytesOut)
+
+	writeHeader(w, ns+"_broadcast_recipients_total", "counter", "Clients reached by broadcasts.")
+	fmt.Fprintf(w, "%s_broadcast_recipients_total %d\n", ns, c.recipients)
+
+	name := ns + "_broadcast_duration_seconds"
+	writeHeader(w, name, "histogram", "Time to fan a broadcast out to all recipients.")
+	for i, bound := range c.config.Buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, c.latencyBuckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, c.broadcasts)
+	fmt.Fprintf(w, "%s_sum %g\n", name, c.latencySum)
+	fmt.Fprintf(w, "%s_count %d\n", name, c.broadcasts)
+}
+
+// typeLabel limits label cardinality since message types come from clients.
+// Caller must hold c.mu.
+func (c *Collector) typeLabel(msgType string) string {
+	if c.knownTypes[msgType] {
+		return msgType
+	}
+	if len(c.knownTypes) >= c.config.MaxTypes {
+		return otherType
+	}
+	c.knownTypes[msgType] = true
+	return msgType
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// writeCounterVec writes a labelled counter in stable label order
+func writeCounterVec(w io.Writer, name, help, label string, values map[string]uint64) {
+	writeHeader(w, name, "counter", help)
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(k), values[k])
+	}
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
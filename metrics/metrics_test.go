@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+func TestCollectorOutput(t *testing.T) {
+	collector := NewCollector(nil)
+	hub := websocket.NewHub(&websocket.Config{Metrics: collector})
+
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Room", Lifecycle: websocket.Persistent})
+	hub.BroadcastToRoom(roomID, websocket.Message{Type: "chat"})
+
+	collector.MessageReceived("chat", 10)
+	collector.MessageReceived("chat", 5)
+	collector.MessageSent("chat", 7)
+	collector.MessageDropped("chat")
+
+	// Plain HTTP request without upgrade headers
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.HandleConnection(hub, w, r, "user1")
+	}))
+	defer server.Close()
+	http.Get(server.URL)
+
+	rec := httptest.NewRecorder()
+	collector.Handler(hub).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"websocket_connected_clients 0",
+		"websocket_rooms 1",
+		`websocket_client_latency_seconds{quantile="0.99"} 0`,
+		`websocket_messages_received_total{type="chat"} 2`,
+		"websocket_received_bytes_total 15",
+		`websocket_messages_sent_total{type="chat"} 1`,
+		`websocket_messages_dropped_total{type="chat"} 1`,
+		`websocket_upgrade_failures_total{reason="not_websocket"} 1`,
+		"websocket_broadcast_duration_seconds_count 1",
+		"# TYPE websocket_broadcast_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected output to contain %q\n%s", want, body)
+		}
+	}
+}
+
+func TestTypeCardinality(t *testing.T) {
+	config := &Config{MaxTypes: 2}
+	collector := NewCollector(config)
+	if config.Namespace != "" || config.Buckets != nil {
+		t.Error("Expected defaults not to be written into the caller's config")
+	}
+	if &collector.config.Buckets[0] == &DefaultBuckets[0] {
+		t.Error("Expected default buckets to be copied")
+	}
+
+	collector.MessageReceived("a", 1)
+	collector.MessageReceived("b", 1)
+	collector.MessageReceived("c", 1)
+	collector.MessageReceived("a", 1)
+
+	rec := httptest.NewRecorder()
+	collector.WriteMetrics(rec, nil)
+	body := rec.Body.String()
+
+	if !strings.Contains(body, `{type="a"} 2`) || !strings.Contains(body, `{type="other"} 1`) {
+		t.Errorf("Expected unknown types to be grouped as other\n%s", body)
+	}
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	collector := NewCollector(&Config{Buckets: []float64{0.001, 0.01}})
+	collector.BroadcastCompleted(3, 5*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	collector.WriteMetrics(rec, nil)
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		`websocket_broadcast_duration_seconds_bucket{le="0.001"} 0`,
+		`websocket_broadcast_duration_seconds_bucket{le="0.01"} 1`,
+		`websocket_broadcast_duration_seconds_bucket{le="+Inf"} 1`,
+		"websocket_broadcast_recipients_total 3",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected output to contain %q\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package websocket
+
+// NetpollConfig enables the event loop mode. Instead of a ReadPump and a
+// WritePump goroutine per connection, one goroutine waits on epoll for all
+// connections and a small pool of workers reads frames from readable
+// sockets and writes queued messages on demand. Idle connections then cost
+// no goroutine stacks, which matters at 100k+ connections.
+//
+// Message handling and hooks run on the workers, one message per connection
+// at a time, so handlers that block hold up other connections; hand long
+// work off to a goroutine. Connections that can't be polled, such as TLS
+// connections or those from a custom Transport other than
+// GorillaTransport, are served with goroutines as before. On platforms
+// other than Linux the mode is unavailable and NewHub logs a warning.
+type NetpollConfig struct {
+	Workers int // goroutines handling reads and writes, 0 = GOMAXPROCS
+}
@@ -0,0 +1,692 @@
+//go:build linux
+
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// pollEvents are the epoll events of a connection. EPOLLONESHOT disarms it
+// after each event, so only one worker reads a connection at a time; it is
+// re-armed once the data read has been handled. EPOLLOUT is added while
+// written data is waiting for room in the socket buffer.
+const pollEvents = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+// wakeID is the epoll event data of the pipe that stops wait. Connection
+// IDs start at 1.
+const wakeID = 0
+
+// errWriteTimeout is returned when written data waited longer than
+// WriteWait for the peer to read it
+var errWriteTimeout = errors.New("write timeout")
+
+// poller serves connections from an epoll instance and a worker pool
+type poller struct {
+	hub     *Hub
+	epfd    int
+	wakeFds [2]int // pipe that stops wait on close
+	done    chan struct{}
+
+	tasks       chan func()
+	tasksClosed bool
+	tasksMu     sync.RWMutex
+
+	// Connections by ID, the epoll event data. IDs rather than file
+	// descriptors, which are reused, keep a late event from reaching a
+	// newer connection.
+	conns  map[int32]*pollConn
+	nextID int32
+	closed bool
+	mu     sync.Mutex
+
+	// Read buffers are shared; only partial frames are kept per connection
+	buffers sync.Pool
+}
+
+// pollConn is a connection served by the poller
+type pollConn struct {
+	id     int32
+	poller *poller
+	client *Client
+	conn   net.Conn
+	raw    syscall.RawConn
+	fd     int
+
+	// Read state of the worker handling the current event. EPOLLONESHOT
+	// already serializes events; readMu orders them in the memory model.
+	pending []byte // incomplete frame
+	message []byte // fragments of the current message
+	msgType int    // opcode of the fragmented message, 0 if none
+	readMu  sync.Mutex
+
+	pongDeadline atomic.Int64 // unix nanos
+	wakeups      atomic.Int32 // since the current flush started
+	parked       atomic.Bool  // flush waits for writable to resume it
+
+	// Frames the socket had no room for, written once it is writable
+	out      []byte
+	outSince time.Time
+	blocked  atomic.Bool // len(out) > 0
+	writeMu  sync.Mutex
+
+	closed bool
+	mu     sync.Mutex // guards closed and the epoll registration
+}
+
+func newPoller(hub *Hub, config *NetpollConfig) (*poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+
+	var wakeFds [2]int
+	if err := syscall.Pipe2(wakeFds[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, os.NewSyscallError("pipe2", err)
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: wakeID}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, wakeFds[0], &event); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(wakeFds[0])
+		syscall.Close(wakeFds[1])
+		return nil, os.NewSyscallError("epoll_ctl", err)
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	bufferSize := hub.config.ReadBufferSize
+	if bufferSize <= 0 {
+		bufferSize = 4096
+	}
+
+	p := &poller{
+		hub:     hub,
+		epfd:    epfd,
+		wakeFds: wakeFds,
+		done:    make(chan struct{}),
+		tasks:   make(chan func(), workers*64),
+		conns:   make(map[int32]*pollConn),
+	}
+	p.buffers.New = func() interface{} {
+		buf := make([]byte, bufferSize)
+		return &buf
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go p.wait()
+	go p.pingLoop()
+
+	return p, nil
+}
+
+// watch prepares a client to be served by the poller and returns the
+// function that starts serving it, or nil if its connection can't be
+// polled
+func (p *poller) watch(client *Client) func() {
+	gc, ok := client.Conn.(*websocket.Conn)
+	if !ok {
+		return nil
+	}
+	conn := gc.NetConn()
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	fd := -1
+	raw.Control(func(f uintptr) { fd = int(f) })
+	if fd < 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	p.nextID++
+	pc := &pollConn{
+		id:     p.nextID,
+		poller: p,
+		client: client,
+		conn:   conn,
+		raw:    raw,
+		fd:     fd,
+	}
+	p.mu.Unlock()
+
+	pc.pongDeadline.Store(p.hub.clock.Now().Add(p.hub.config.PongWait).UnixNano())
+	client.wake = pc.wake
+
+	return pc.start
+}
+
+// close closes all connections and stops the poller's goroutines. Tasks
+// submitted afterwards are dropped.
+func (p *poller) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	conns := make([]*pollConn, 0, len(p.conns))
+	for _, pc := range p.conns {
+		conns = append(conns, pc)
+	}
+	p.mu.Unlock()
+
+	for _, pc := range conns {
+		pc.write(CloseMessage, []byte{})
+		pc.close()
+	}
+
+	// Stop pingLoop and wait, which closes the epoll instance
+	close(p.done)
+	syscall.Write(p.wakeFds[1], []byte{0})
+
+	p.tasksMu.Lock()
+	p.tasksClosed = true
+	close(p.tasks)
+	p.tasksMu.Unlock()
+}
+
+// work runs tasks until the poller is closed
+func (p *poller) work() {
+	for task := range p.tasks {
+		task()
+	}
+}
+
+// submit queues a task for the workers. It never blocks, as callers may
+// hold hub or room locks that a worker needs.
+func (p *poller) submit(task func()) {
+	p.tasksMu.RLock()
+	defer p.tasksMu.RUnlock()
+
+	if p.tasksClosed {
+		return
+	}
+	select {
+	case p.tasks <- task:
+	default:
+		go task()
+	}
+}
+
+// readyConn is a connection with the events epoll reported for it
+type readyConn struct {
+	pc     *pollConn
+	events uint32
+}
+
+// wait dispatches ready connections to the workers until the poller is
+// closed
+func (p *poller) wait() {
+	defer func() {
+		syscall.Close(p.epfd)
+		syscall.Close(p.wakeFds[0])
+		syscall.Close(p.wakeFds[1])
+	}()
+
+	events := make([]syscall.EpollEvent, 256)
+	ready := make([]readyConn, 0, len(events))
+
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			p.hub.logger.Error("epoll wait failed, connections are no longer read", "error", err)
+			return
+		}
+
+		ready = ready[:0]
+		p.mu.Lock()
+		closed := p.closed
+		for _, event := range events[:n] {
+			if pc := p.conns[event.Fd]; pc != nil {
+				ready = append(ready, readyConn{pc: pc, events: event.Events})
+			}
+		}
+		p.mu.Unlock()
+		if closed {
+			return
+		}
+
+		for _, r := range ready {
+			pc, events := r.pc, r.events
+			p.submit(func() { pc.handle(events) })
+		}
+	}
+}
+
+// pingLoop pings all connections every PingInterval and closes those that
+// missed the pong deadline
+func (p *poller) pingLoop() {
+	ticker := p.hub.clock.NewTicker(p.hub.config.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-p.done:
+			return
+		}
+		now := p.hub.clock.Now().UnixNano()
+
+		p.mu.Lock()
+		conns := make([]*pollConn, 0, len(p.conns))
+		for _, pc := range p.conns {
+			conns = append(conns, pc)
+		}
+		p.mu.Unlock()
+
+		for _, pc := range conns {
+			if now > pc.pongDeadline.Load() {
+				pc.client.logger().Debug("pong timeout")
+				p.submit(pc.close)
+				continue
+			}
+			p.submit(pc.ping)
+		}
+	}
+}
+
+// start registers the connection with epoll
+func (pc *pollConn) start() {
+	p := pc.poller
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		pc.close()
+		return
+	}
+	p.conns[pc.id] = pc
+	p.mu.Unlock()
+
+	// The poller may close the connection as soon as it is in p.conns
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return
+	}
+	event := syscall.EpollEvent{Events: pollEvents, Fd: pc.id}
+	err := syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, pc.fd, &event)
+	pc.mu.Unlock()
+	if err != nil {
+		pc.client.logger().Error("epoll registration failed", "error", err)
+		pc.close()
+		return
+	}
+
+	// Flush messages sent while registering
+	pc.wake()
+}
+
+// handle handles the events epoll reported for the connection
+func (pc *pollConn) handle(events uint32) {
+	if events&syscall.EPOLLOUT != 0 {
+		pc.writable()
+	}
+	if events&^syscall.EPOLLOUT != 0 {
+		// read re-arms the connection
+		pc.read()
+		return
+	}
+	pc.rearm()
+}
+
+// rearm waits for the next readable event, and for room in the socket
+// buffer while written data is pending
+func (pc *pollConn) rearm() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	// A closed descriptor may already belong to another connection
+	if pc.closed {
+		return
+	}
+	events := uint32(pollEvents)
+	if pc.blocked.Load() {
+		events |= syscall.EPOLLOUT
+	}
+	event := syscall.EpollEvent{Events: events, Fd: pc.id}
+	if err := syscall.EpollCtl(pc.poller.epfd, syscall.EPOLL_CTL_MOD, pc.fd, &event); err != nil {
+		pc.client.logger().Warn("epoll rearm failed", "error", err)
+	}
+}
+
+// close closes the connection and unregisters the client
+func (pc *pollConn) close() {
+	pc.mu.Lock()
+	if pc.closed {
+		pc.mu.Unlock()
+		return
+	}
+	pc.closed = true
+	syscall.EpollCtl(pc.poller.epfd, syscall.EPOLL_CTL_DEL, pc.fd, &syscall.EpollEvent{})
+	pc.client.Conn.Close()
+	pc.mu.Unlock()
+
+	p := pc.poller
+	p.mu.Lock()
+	delete(p.conns, pc.id)
+	p.mu.Unlock()
+
+	p.hub.unregisterClient(pc.client)
+}
+
+// read handles the data available on the socket
+func (pc *pollConn) read() {
+	pc.readMu.Lock()
+	defer pc.readMu.Unlock()
+
+	bufp := pc.poller.buffers.Get().(*[]byte)
+	defer pc.poller.buffers.Put(bufp)
+
+	// Read without waiting; the event said there is data
+	var n int
+	var readErr error
+	err := pc.raw.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), *bufp)
+		return true
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err == syscall.EAGAIN || err == syscall.EINTR {
+		pc.rearm()
+		return
+	}
+	if err != nil || n <= 0 {
+		// Reset or end of stream
+		pc.close()
+		return
+	}
+
+	data := (*bufp)[:n]
+	if len(pc.pending) > 0 {
+		data = append(pc.pending, data...)
+	}
+
+	rest, err := pc.handleFrames(data)
+	if err != nil {
+		pc.fail(err)
+		return
+	}
+
+	// Keep an incomplete frame for the next read, releasing memory between
+	// messages
+	if len(rest) > 0 {
+		pc.pending = append(pc.pending[:0], rest...)
+	} else {
+		pc.pending = nil
+	}
+	pc.rearm()
+}
+
+// handleFrames handles the complete frames in data and returns the rest
+func (pc *pollConn) handleFrames(data []byte) ([]byte, error) {
+	limit := pc.poller.hub.config.MaxMessageSize
+	for {
+		f, n, err := parseFrame(data, limit)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return data, nil
+		}
+		data = data[n:]
+
+		if err := pc.handleFrame(f, limit); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// handleFrame handles one frame, assembling fragmented messages
+func (pc *pollConn) handleFrame(f frame, limit int64) error {
+	c := pc.client
+
+	switch f.opcode {
+	case PingMessage:
+		return pc.write(PongMessage, f.payload)
+
+	case PongMessage:
+		pc.pongDeadline.Store(c.Hub.clock.Now().Add(c.Hub.config.PongWait).UnixNano())
+		c.recordPong(string(f.payload))
+		return nil
+
+	case CloseMessage:
+		closeErr := &websocket.CloseError{Code: websocket.CloseNoStatusReceived}
+		if len(f.payload) >= 2 {
+			closeErr.Code = int(f.payload[0])<<8 | int(f.payload[1])
+			closeErr.Text = string(f.payload[2:])
+		}
+		return closeErr
+
+	case TextMessage, BinaryMessage:
+		if pc.msgType != 0 {
+			return errProtocol
+		}
+		if f.fin {
+			c.handleData(f.payload)
+			return nil
+		}
+		pc.msgType = f.opcode
+		pc.message = append([]byte(nil), f.payload...)
+		return nil
+
+	default: // continuation
+		if pc.msgType == 0 {
+			return errProtocol
+		}
+		if limit > 0 && int64(len(pc.message)+len(f.payload)) > limit {
+			return errMessageTooLarge
+		}
+		pc.message = append(pc.message, f.payload...)
+		if f.fin {
+			c.handleData(pc.message)
+			pc.message = nil
+			pc.msgType = 0
+		}
+		return nil
+	}
+}
+
+// fail answers a close frame or protocol error and closes the connection
+func (pc *pollConn) fail(err error) {
+	var closeErr *websocket.CloseError
+	switch {
+	case errors.As(err, &closeErr):
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			pc.client.logger().Warn("websocket read error", "error", err)
+		}
+		pc.write(CloseMessage, websocket.FormatCloseMessage(closeErr.Code, ""))
+	case errors.Is(err, errMessageTooLarge):
+		pc.client.logger().Warn("websocket read error", "error", err)
+		pc.write(CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""))
+	default:
+		pc.client.logger().Warn("websocket read error", "error", err)
+		pc.write(CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, ""))
+	}
+	pc.close()
+}
+
+// wake schedules a flush of the client's Send channel
+func (pc *pollConn) wake() {
+	if pc.wakeups.Add(1) == 1 {
+		pc.poller.submit(pc.flush)
+	}
+}
+
+// flush writes queued messages until the Send channel is empty. Wake-ups
+// during the flush make it check again rather than start another one.
+// While the socket buffer is full the flush parks, leaving the rest in
+// Send, and writable resumes it.
+func (pc *pollConn) flush() {
+	c := pc.client
+	for {
+		wakeups := pc.wakeups.Load()
+
+		for drained := false; !drained; {
+			if pc.blocked.Load() && pc.park() {
+				return
+			}
+
+			select {
+			case message, ok := <-c.Send:
+				if !ok {
+					// Hub closed the channel
+					pc.write(CloseMessage, []byte{})
+					pc.close()
+					return
+				}
+
+				messageBytes, err := json.Marshal(message)
+				if err != nil {
+					c.logger().Error("failed to marshal message", "type", message.Type, "error", err)
+					continue
+				}
+				if err := pc.write(TextMessage, messageBytes); err != nil {
+					pc.close()
+					return
+				}
+				if c.Hub.config.Metrics != nil {
+					c.Hub.config.Metrics.MessageSent(message.Type, len(messageBytes))
+				}
+			default:
+				drained = true
+			}
+		}
+
+		if pc.wakeups.Add(-wakeups) == 0 {
+			return
+		}
+	}
+}
+
+// park hands the flush over to writable. It reports false if the socket
+// drained meanwhile and the caller should go on flushing.
+func (pc *pollConn) park() bool {
+	pc.parked.Store(true)
+	if pc.blocked.Load() {
+		return true
+	}
+	// Whoever clears parked owns the flush
+	return !pc.parked.CompareAndSwap(true, false)
+}
+
+// ping sends a ping stamped with the current time
+func (pc *pollConn) ping() {
+	if err := pc.write(PingMessage, pingPayload(pc.poller.hub.clock.Now())); err != nil {
+		pc.close()
+	}
+}
+
+// write writes one unfragmented frame without waiting. What the socket has
+// no room for is kept and written once it is writable; the write fails if
+// earlier data has been waiting for longer than WriteWait.
+func (pc *pollConn) write(opcode int, payload []byte) error {
+	frame := appendFrameHeader(make([]byte, 0, maxFrameHeader+len(payload)), opcode, len(payload))
+	frame = append(frame, payload...)
+
+	pc.writeMu.Lock()
+	if len(pc.out) > 0 {
+		defer pc.writeMu.Unlock()
+		if time.Since(pc.outSince) > pc.poller.hub.config.WriteWait {
+			return errWriteTimeout
+		}
+		pc.out = append(pc.out, frame...)
+		return nil
+	}
+
+	n, err := pc.writeRaw(frame)
+	if err != nil || n == len(frame) {
+		pc.writeMu.Unlock()
+		return err
+	}
+	pc.out = append([]byte(nil), frame[n:]...)
+	pc.outSince = time.Now()
+	pc.blocked.Store(true)
+	pc.writeMu.Unlock()
+
+	// Wait for room in the socket buffer
+	pc.rearm()
+	return nil
+}
+
+// writable writes the data kept by write and resumes a parked flush once
+// all of it is written
+func (pc *pollConn) writable() {
+	pc.writeMu.Lock()
+	if len(pc.out) == 0 {
+		pc.writeMu.Unlock()
+		return
+	}
+	n, err := pc.writeRaw(pc.out)
+	if err != nil {
+		pc.writeMu.Unlock()
+		if !errors.Is(err, net.ErrClosed) {
+			pc.client.logger().Warn("websocket write error", "error", err)
+		}
+		pc.close()
+		return
+	}
+	pc.out = pc.out[n:]
+	if len(pc.out) > 0 {
+		pc.writeMu.Unlock()
+		return
+	}
+	pc.out = nil
+	pc.blocked.Store(false)
+	pc.writeMu.Unlock()
+
+	if pc.parked.CompareAndSwap(true, false) {
+		pc.poller.submit(pc.flush)
+	}
+}
+
+// writeRaw writes as much of b as the socket buffer takes and returns the
+// number of bytes written. Caller must hold pc.writeMu.
+func (pc *pollConn) writeRaw(b []byte) (int, error) {
+	written := 0
+	var writeErr error
+	err := pc.raw.Write(func(fd uintptr) bool {
+		for written < len(b) {
+			n, err := syscall.Write(int(fd), b[written:])
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				break
+			}
+			if err != nil {
+				writeErr = err
+				break
+			}
+			written += n
+		}
+		return true
+	})
+	if err == nil {
+		err = writeErr
+	}
+	return written, err
+}
@@ -0,0 +1,226 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newNetpollServer serves a netpoll hub, taking the user ID from the query
+func newNetpollServer(t *testing.T, config *Config) (*Hub, string) {
+	if config.Netpoll == nil {
+		config.Netpoll = &NetpollConfig{Workers: 4}
+	}
+	hub := NewHub(config)
+	if hub.poller == nil {
+		t.Fatal("Expected netpoll mode on Linux")
+	}
+	go hub.Run()
+	t.Cleanup(hub.Close)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleConnection(hub, w, r, r.URL.Query().Get("user_id"))
+	}))
+	t.Cleanup(server.Close)
+	return hub, "ws" + strings.TrimPrefix(server.URL, "http") + "?user_id="
+}
+
+func dialNetpoll(t *testing.T, hub *Hub, url, userID string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(url+userID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	for i := 0; i < 200 && hub.GetClient(userID) == nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hub.GetClient(userID) == nil {
+		t.Fatalf("%s was not registered", userID)
+	}
+	return conn
+}
+
+// readType reads until a message of the given type
+func readType(t *testing.T, conn *websocket.Conn, msgType string) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Expected %s message: %v", msgType, err)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+func TestNetpoll(t *testing.T) {
+	hub, url := newNetpollServer(t, DefaultConfig())
+
+	received := make(chan Message, 16)
+	hub.OnMessage(func(ctx context.Context, client *Client, msg Message) {
+		received <- msg
+		roomID, _ := msg.Data["room_id"].(string)
+		hub.BroadcastToRoom(roomID, msg)
+	})
+
+	alice := dialNetpoll(t, hub, url, "alice")
+	bob := dialNetpoll(t, hub, url, "bob")
+	roomID := hub.CreateRoom(&RoomConfig{Name: "Lobby"})
+	hub.JoinRoom("alice", roomID)
+	hub.JoinRoom("bob", roomID)
+
+	alice.WriteJSON(Message{Type: "chat", Data: map[string]interface{}{"room_id": roomID, "text": "hi"}})
+	if msg := readType(t, bob, "chat"); msg.Data["text"] != "hi" {
+		t.Errorf("Expected broadcast from alice, got %v", msg.Data)
+	}
+	readType(t, alice, "chat")
+	<-received
+
+	// Larger than the dialer's write buffer, so sent in fragments
+	long := strings.Repeat("x", 10000)
+	bob.WriteJSON(Message{Type: "chat", Data: map[string]interface{}{"room_id": roomID, "text": long}})
+	if msg := readType(t, alice, "chat"); msg.Data["text"] != long {
+		t.Error("Expected fragmented message to be reassembled")
+	}
+	<-received
+
+	// Closing the connection unregisters the client
+	bob.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	for i := 0; i < 200 && hub.GetClient("bob") != nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hub.GetClient("bob") != nil {
+		t.Error("Expected bob to be unregistered after closing")
+	}
+	if clients := hub.GetRoomClients(roomID); len(clients) != 1 {
+		t.Errorf("Expected bob to leave the room, got %v", clients)
+	}
+
+	// Disconnecting from the hub side sends a close frame
+	hub.DisconnectUser("alice")
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := alice.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNoStatusReceived) {
+				t.Errorf("Expected close frame, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestNetpollLimitsAndPings(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxMessageSize = 1024
+	config.PingInterval = 20 * time.Millisecond
+	hub, url := newNetpollServer(t, config)
+
+	// Pongs are sent while the client reads
+	alice := dialNetpoll(t, hub, url, "alice")
+	go func() {
+		for {
+			if _, _, err := alice.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < 200 && hub.GetClient("alice").Latency() == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hub.GetClient("alice").Latency() == 0 {
+		t.Error("Expected a latency sample from the pings")
+	}
+
+	bob := dialNetpoll(t, hub, url, "bob")
+	bob.WriteMessage(websocket.TextMessage, bytes.Repeat([]byte("x"), 2048))
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := bob.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+				t.Errorf("Expected close for a too large message, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestNetpollGoroutines(t *testing.T) {
+	hub, url := newNetpollServer(t, DefaultConfig())
+	before := runtime.NumGoroutine()
+
+	const clients = 50
+	for i := 0; i < clients; i++ {
+		dialNetpoll(t, hub, url, "user-"+string(rune('a'+i%26))+string(rune('a'+i/26)))
+	}
+
+	// Goroutine mode would add a ReadPump and a WritePump per connection
+	if added := runtime.NumGoroutine() - before; added >= clients {
+		t.Errorf("Expected no goroutines per connection, got %d for %d connections", added, clients)
+	}
+}
+
+func TestNetpollSlowReader(t *testing.T) {
+	config := DefaultConfig()
+	config.Netpoll = &NetpollConfig{Workers: 1}
+	hub, url := newNetpollServer(t, config)
+
+	// slow never reads, so its socket buffers fill up
+	dialNetpoll(t, hub, url, "slow")
+	fast := dialNetpoll(t, hub, url, "fast")
+
+	big := strings.Repeat("x", 64*1024)
+	for i := 0; i < 200; i++ {
+		hub.SendToUser("slow", Message{Type: "chat", Data: map[string]interface{}{"text": big}})
+	}
+
+	// The only worker must not be stuck writing to slow
+	start := time.Now()
+	hub.SendToUser("fast", Message{Type: "chat", Data: map[string]interface{}{"text": "hi"}})
+	readType(t, fast, "chat")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected a full socket not to block other connections, took %v", elapsed)
+	}
+}
+
+func TestNetpollClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	config := DefaultConfig()
+	config.Netpoll = &NetpollConfig{Workers: 4}
+	hub := NewHub(config)
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleConnection(hub, w, r, r.URL.Query().Get("user_id"))
+	}))
+	alice := dialNetpoll(t, hub, "ws"+strings.TrimPrefix(server.URL, "http")+"?user_id=", "alice")
+	server.Close()
+
+	hub.Close()
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := alice.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNoStatusReceived) {
+		t.Errorf("Expected close frame, got %v", err)
+	}
+	if hub.GetOnlineCount() != 0 {
+		t.Error("Expected clients to be disconnected")
+	}
+
+	// Workers, the epoll wait, the ping loop and Run all exit
+	alice.Close()
+	for i := 0; i < 200 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected goroutines to exit after Close, %d before and %d after", before, after)
+	}
+}
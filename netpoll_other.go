@@ -0,0 +1,16 @@
+//go:build !linux
+
+package websocket
+
+import "errors"
+
+// poller is only implemented on Linux
+type poller struct{}
+
+func newPoller(hub *Hub, config *NetpollConfig) (*poller, error) {
+	return nil, errors.New("netpoll mode requires Linux")
+}
+
+func (p *poller) watch(client *Client) func() {
+	return nil
+}
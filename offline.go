@@ -0,0 +1,137 @@
+package websocket
+
+import (
+	"errors"
+)
+
+// SendToUserWithStatus sends a message to a user and reports whether it was
+// delivered, queued for when the user connects, or failed
+func (h *Hub) SendToUserWithStatus(userID string, msg Message) (DeliveryStatus, error) {
+	return h.SendFromUser("", userID, msg)
+}
+
+// SendFromUser sends a message on behalf of another user. If the message is
+// queued, the sender receives message_queued now and message_delivered once
+// the recipient connects and the message is flushed.
+func (h *Hub) SendFromUser(fromUserID, userID string, msg Message) (DeliveryStatus, error) {
+	if client := h.GetClient(userID); client != nil {
+		client.SendMessage(msg)
+		return DeliveryDelivered, nil
+	}
+
+	if h.offline == nil {
+		return DeliveryFailed, errors.New("user not connected")
+	}
+
+	entry, client, err := h.enqueueOffline(fromUserID, userID, msg)
+	if client != nil {
+		client.SendMessage(msg)
+		return DeliveryDelivered, nil
+	}
+	if err != nil {
+		return DeliveryFailed, err
+	}
+
+	h.logger.Debug("message queued for offline user", "user_id", userID, "type", msg.Type, "message_id", entry.ID)
+
+	if fromUserID != "" {
+		h.notifyDelivery(fromUserID, userID, entry, DeliveryQueued)
+	}
+	return DeliveryQueued, nil
+}
+
+// enqueueOffline queues a message unless the user connected while waiting
+// for h.offlineMu, in which case it returns the client to send to instead.
+// Sending happens after the lock is released.
+func (h *Hub) enqueueOffline(fromUserID, userID string, msg Message) (HistoryEntry, *Client, error) {
+	h.offlineMu.Lock()
+	defer h.offlineMu.Unlock()
+
+	if client := h.GetClient(userID); client != nil {
+		return HistoryEntry{}, client, nil
+	}
+
+	entry, err := h.offline.Enqueue(userID, fromUserID, msg)
+	if err != nil {
+		return HistoryEntry{}, nil, err
+	}
+
+	if err := h.trimOffline(userID); err != nil {
+		h.logger.Warn("failed to trim offline messages", "user_id", userID, "error", err)
+	}
+	return entry, nil, nil
+}
+
+// GetPendingMessages returns the messages queued for a disconnected user
+func (h *Hub) GetPendingMessages(userID string) ([]HistoryEntry, error) {
+	if h.offline == nil {
+		return nil, errors.New("offline messages not enabled")
+	}
+	return h.offline.Pending(userID)
+}
+
+// flushOffline sends queued messages to a newly registered client in order
+// and returns the delivered entries. Caller must hold h.offlineMu.
+func (h *Hub) flushOffline(client *Client) []HistoryEntry {
+	if err := h.trimOffline(client.UserID); err != nil {
+		h.logger.Warn("failed to trim offline messages", "user_id", client.UserID, "error", err)
+	}
+
+	pending, err := h.offline.Pending(client.UserID)
+	if err != nil {
+		h.logger.Error("failed to load offline messages", "user_id", client.UserID, "error", err)
+		return nil
+	}
+
+	var lastID int64
+	delivered := make([]HistoryEntry, 0, len(pending))
+flush:
+	for _, entry := range pending {
+		// Never block registration; the rest stays queued for next time
+		select {
+		case client.Send <- entry.Message:
+		default:
+			break flush
+		}
+		lastID = entry.ID
+		delivered = append(delivered, entry)
+	}
+
+	if lastID == 0 {
+		return nil
+	}
+	if err := h.offline.Ack(client.UserID, lastID); err != nil {
+		h.logger.Error("failed to acknowledge offline messages", "user_id", client.UserID, "error", err)
+	}
+
+	h.logger.Info("offline messages delivered", "user_id", client.UserID, "client_id", client.ID, "count", len(delivered))
+	return delivered
+}
+
+// trimOffline applies the per-user cap and TTL to queued messages
+func (h *Hub) trimOffline(userID string) error {
+	cfg := h.config.Offline
+	maxMessages := cfg.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = 100
+	}
+	return h.offline.TrimPending(userID, maxMessages, cfg.TTL)
+}
+
+// notifyDelivery tells a connected sender about the status of an offline message
+func (h *Hub) notifyDelivery(fromUserID, userID string, entry HistoryEntry, status DeliveryStatus) {
+	sender := h.GetClient(fromUserID)
+	if sender == nil {
+		return
+	}
+
+	sender.SendMessage(Message{
+		Type: "message_" + string(status),
+		Data: map[string]interface{}{
+			"user_id":      userID,
+			"message_id":   entry.ID,
+			"message_type": entry.Message.Type,
+			"status":       string(status),
+		},
+	})
+}
@@ -0,0 +1,298 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// PresenceStatus is a user's availability
+type PresenceStatus string
+
+const (
+	StatusOnline  PresenceStatus = "online"
+	StatusAway    PresenceStatus = "away"
+	StatusBusy    PresenceStatus = "busy"
+	StatusOffline PresenceStatus = "offline"
+)
+
+// Presence describes a user's current status
+type Presence struct {
+	UserID   string         `json:"user_id"`
+	Status   PresenceStatus `json:"status"`
+	Text     string         `json:"text,omitempty"` // custom status text
+	LastSeen time.Time      `json:"last_seen"`      // zero while online or if never seen
+}
+
+// PresenceConfig enables the presence subsystem
+type PresenceConfig struct {
+	// Changes are announced only after they have been stable for this long,
+	// so a quick disconnect/reconnect produces no events. 0 = immediate.
+	Debounce time.Duration
+}
+
+// presenceTracker holds presence state and subscriptions
+type presenceTracker struct {
+	debounce time.Duration
+
+	current   map[string]*Presence
+	announced map[string]Presence
+	timers    map[string]Timer
+
+	// target -> subscribers, and subscriber -> targets for cleanup
+	subscribers   map[string]map[string]bool
+	subscriptions map[string]map[string]bool
+
+	mu sync.Mutex
+}
+
+func newPresenceTracker(config *PresenceConfig) *presenceTracker {
+	return &presenceTracker{
+		debounce:      config.Debounce,
+		current:       make(map[string]*Presence),
+		announced:     make(map[string]Presence),
+		timers:        make(map[string]Timer),
+		subscribers:   make(map[string]map[string]bool),
+		subscriptions: make(map[string]map[string]bool),
+	}
+}
+
+// SetPresence sets a connected user's status and optional custom text
+func (h *Hub) SetPresence(userID string, status PresenceStatus, text string) error {
+	if h.presence == nil {
+		return errors.New("presence not enabled")
+	}
+	if status == StatusOffline {
+		return errors.New("offline status is set on disconnect")
+	}
+	if h.GetClient(userID) == nil {
+		return errors.New("client not connected")
+	}
+
+	h.updatePresence(userID, func(p *Presence) {
+		p.Status = status
+		p.Text = text
+	})
+	return nil
+}
+
+// GetPresence returns a user's current presence
+func (h *Hub) GetPresence(userID string) Presence {
+	if h.presence == nil {
+		if h.GetClient(userID) != nil {
+			return Presence{UserID: userID, Status: StatusOnline}
+		}
+		return Presence{UserID: userID, Status: StatusOffline}
+	}
+
+	h.presence.mu.Lock()
+	defer h.presence.mu.Unlock()
+
+	if p, ok := h.presence.current[userID]; ok {
+		return *p
+	}
+	return Presence{UserID: userID, Status: StatusOffline}
+}
+
+// SubscribePresence subscribes a user to presence changes of other users.
+// The current presence of each user is sent right away.
+func (h *Hub) SubscribePresence(subscriberID string, userIDs ...string) error {
+	if h.presence == nil {
+		return errors.New("presence not enabled")
+	}
+
+	t := h.presence
+	t.mu.Lock()
+	if t.subscriptions[subscriberID] == nil {
+		t.subscriptions[subscriberID] = make(map[string]bool)
+	}
+	for _, userID := range userIDs {
+		if t.subscribers[userID] == nil {
+			t.subscribers[userID] = make(map[string]bool)
+		}
+		t.subscribers[userID][subscriberID] = true
+		t.subscriptions[subscriberID][userID] = true
+	}
+	t.mu.Unlock()
+
+	if client := h.GetClient(subscriberID); client != nil {
+		for _, userID := range userIDs {
+			client.SendMessage(presenceMessage(h.GetPresence(userID)))
+		}
+	}
+	return nil
+}
+
+// UnsubscribePresence removes presence subscriptions
+func (h *Hub) UnsubscribePresence(subscriberID string, userIDs ...string) {
+	if h.presence == nil {
+		return
+	}
+
+	t := h.presence
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, userID := range userIDs {
+		t.unsubscribe(subscriberID, userID)
+	}
+}
+
+// unsubscribe removes one subscription. Caller must hold t.mu.
+func (t *presenceTracker) unsubscribe(subscriberID, userID string) {
+	delete(t.subscribers[userID], subscriberID)
+	if len(t.subscribers[userID]) == 0 {
+		delete(t.subscribers, userID)
+	}
+	delete(t.subscriptions[subscriberID], userID)
+	if len(t.subscriptions[subscriberID]) == 0 {
+		delete(t.subscriptions, subscriberID)
+	}
+}
+
+// presenceConnected marks a user online. Custom text is kept across
+// reconnects.
+func (h *Hub) presenceConnected(userID string) {
+	h.updatePresence(userID, func(p *Presence) {
+		p.Status = StatusOnline
+		p.LastSeen = time.Time{}
+	})
+}
+
+// presenceDisconnected marks a user offline and records last-seen. The
+// user's own subscriptions are dropped once the offline status is
+// announced, so a reconnect within the debounce window keeps them.
+func (h *Hub) presenceDisconnected(userID string) {
+	h.updatePresence(userID, func(p *Presence) {
+		p.Status = StatusOffline
+		p.LastSeen = h.clock.Now()
+	})
+}
+
+// updatePresence applies a change and schedules its announcement
+func (h *Hub) updatePresence(userID string, change func(*Presence)) {
+	t := h.presence
+	t.mu.Lock()
+
+	p, ok := t.current[userID]
+	if !ok {
+		p = &Presence{UserID: userID, Status: StatusOffline}
+		t.current[userID] = p
+	}
+	change(p)
+
+	if t.debounce <= 0 {
+		t.mu.Unlock()
+		h.announcePresence(userID)
+		return
+	}
+
+	// Restart the debounce window
+	if timer, ok := t.timers[userID]; ok {
+		timer.Stop()
+	}
+	t.timers[userID] = h.clock.AfterFunc(t.debounce, func() {
+		h.announcePresence(userID)
+	})
+	t.mu.Unlock()
+}
+
+// announcePresence sends presence.changed to subscribers if the user's
+// presence differs from what was last announced
+func (h *Hub) announcePresence(userID string) {
+	t := h.presence
+	t.mu.Lock()
+
+	delete(t.timers, userID)
+	p := *t.current[userID]
+	if p.Status == StatusOffline {
+		for target := range t.subscriptions[userID] {
+			t.unsubscribe(userID, target)
+		}
+	}
+	last, ok := t.announced[userID]
+	if ok && last.Status == p.Status && last.Text == p.Text {
+		t.mu.Unlock()
+		return
+	}
+	t.announced[userID] = p
+
+	subscribers := make([]string, 0, len(t.subscribers[userID]))
+	for subscriberID := range t.subscribers[userID] {
+		subscribers = append(subscribers, subscriberID)
+	}
+	t.mu.Unlock()
+
+	msg := presenceMessage(p)
+	for _, subscriberID := range subscribers {
+		if client := h.GetClient(subscriberID); client != nil {
+			client.SendMessage(msg)
+		}
+	}
+}
+
+// handlePresenceMessage handles presence.* messages sent by clients
+func (h *Hub) handlePresenceMessage(client *Client, msg Message) {
+	switch msg.Type {
+	case "presence.set":
+		status, _ := msg.Data["status"].(string)
+		text, _ := msg.Data["text"].(string)
+		if status == "" {
+			status = string(StatusOnline)
+		}
+		if err := h.SetPresence(client.UserID, PresenceStatus(status), text); err != nil {
+			client.SendMessage(errorMessage(msg.Type, err))
+		}
+
+	case "presence.subscribe":
+		if err := h.SubscribePresence(client.UserID, stringList(msg.Data["user_ids"])...); err != nil {
+			client.SendMessage(errorMessage(msg.Type, err))
+		}
+
+	case "presence.unsubscribe":
+		h.UnsubscribePresence(client.UserID, stringList(msg.Data["user_ids"])...)
+	}
+}
+
+// presenceMessage builds a presence.changed message
+func presenceMessage(p Presence) Message {
+	data := map[string]interface{}{
+		"user_id": p.UserID,
+		"status":  string(p.Status),
+	}
+	if p.Text != "" {
+		data["text"] = p.Text
+	}
+	if !p.LastSeen.IsZero() {
+		data["last_seen"] = p.LastSeen
+	}
+	return Message{Type: "presence.changed", Data: data}
+}
+
+// errorMessage builds an error reply for a client request
+func errorMessage(requestType string, err error) Message {
+	return Message{
+		Type: "error",
+		Data: map[string]interface{}{
+			"request": requestType,
+			"error":   err.Error(),
+		},
+	}
+}
+
+// stringList converts a decoded JSON array to a string slice
+func stringList(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+)
+
+// readCursors tracks the last history message ID each user has read per room
+type readCursors struct {
+	rooms map[string]map[string]int64 // roomID -> userID -> message ID
+	mu    sync.RWMutex
+}
+
+func newReadCursors() *readCursors {
+	return &readCursors{
+		rooms: make(map[string]map[string]int64),
+	}
+}
+
+// MarkRead advances a user's read cursor in a room and sends a room.receipt
+// to the other members. Only members can mark messages read. Cursors never
+// move backwards.
+func (h *Hub) MarkRead(roomID, userID string, messageID int64) error {
+	room := h.GetRoom(roomID)
+	if room == nil {
+		return errors.New("room not found")
+	}
+	if room.History == nil {
+		return errors.New("room history not enabled")
+	}
+
+	room.mu.RLock()
+	_, member := room.Clients[userID]
+	room.mu.RUnlock()
+	if !member {
+		return errors.New("not a member of this room")
+	}
+	if messageID <= 0 {
+		return errors.New("invalid message id")
+	}
+
+	// Clamp to the newest message in the room
+	latest, err := h.store.History(roomID, 0, 1)
+	if err != nil {
+		return err
+	}
+	if len(latest) == 0 {
+		return errors.New("room has no messages")
+	}
+	if messageID > latest[0].ID {
+		messageID = latest[0].ID
+	}
+
+	h.cursors.mu.Lock()
+	users, ok := h.cursors.rooms[roomID]
+	if !ok {
+		users = make(map[string]int64)
+		h.cursors.rooms[roomID] = users
+	}
+	if messageID <= users[userID] {
+		h.cursors.mu.Unlock()
+		return nil
+	}
+	users[userID] = messageID
+	h.cursors.mu.Unlock()
+
+	receipt := Message{
+		Type: "room.receipt",
+		Data: map[string]interface{}{
+			"room_id":    roomID,
+			"user_id":    userID,
+			"message_id": messageID,
+		},
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	for memberID, client := range room.Clients {
+		if memberID != userID {
+			client.SendMessage(receipt)
+		}
+	}
+	return nil
+}
+
+// GetReadCursor returns the last message ID a user has read in a room
+func (h *Hub) GetReadCursor(roomID, userID string) int64 {
+	h.cursors.mu.RLock()
+	defer h.cursors.mu.RUnlock()
+	return h.cursors.rooms[roomID][userID]
+}
+
+// GetRoomReadCursors returns the read cursors of all users in a room
+func (h *Hub) GetRoomReadCursors(roomID string) map[string]int64 {
+	h.cursors.mu.RLock()
+	defer h.cursors.mu.RUnlock()
+
+	cursors := make(map[string]int64, len(h.cursors.rooms[roomID]))
+	for userID, messageID := range h.cursors.rooms[roomID] {
+		cursors[userID] = messageID
+	}
+	return cursors
+}
+
+// GetUnreadCount returns how many history messages in a room are newer
+// than the user's read cursor
+func (h *Hub) GetUnreadCount(roomID, userID string) (int, error) {
+	room := h.GetRoom(roomID)
+	if room != nil && room.History == nil {
+		return 0, errors.New("room history not enabled")
+	}
+
+	entries, err := h.store.Since(roomID, h.GetReadCursor(roomID, userID))
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// deleteReadCursors forgets all cursors of a room
+func (h *Hub) deleteReadCursors(roomID string) {
+	h.cursors.mu.Lock()
+	defer h.cursors.mu.Unlock()
+	delete(h.cursors.rooms, roomID)
+}
+
+// handleReadMessage handles room.read messages sent by clients
+func (h *Hub) handleReadMessage(client *Client, msg Message) {
+	roomID, _ := msg.Data["room_id"].(string)
+
+	var messageID int64
+	switch v := msg.Data["message_id"].(type) {
+	case float64:
+		messageID = int64(v)
+	case int64:
+		messageID = v
+	case int:
+		messageID = int64(v)
+	}
+
+	if err := h.MarkRead(roomID, client.UserID, messageID); err != nil {
+		client.SendMessage(errorMessage(msg.Type, err))
+	}
+}
@@ -1,31 +1,31 @@
 package websocket
 
 import (
+	"context"
 	"errors"
-	"log"
+	"strconv"
 	"time"
 )
 
+// ErrNotInRoom is returned by KickFromRoom when the user is not a member
+var ErrNotInRoom = errors.New("user not in room")
+
 // CreateRoom creates a new room
 func (h *Hub) CreateRoom(config *RoomConfig) string {
 	roomID := generateRoomID()
+	room := newRoom(roomID, config)
 
-	room := &Room{
-		ID:         roomID,
-		Name:       config.Name,
-		Clients:    make(map[string]*Client),
-		MaxClients: config.MaxClients,
-		IsPrivate:  config.IsPrivate,
-		Password:   config.Password,
-		CreatedAt:  time.Now(),
-		Metadata:   config.Metadata,
-	}
+	shard := h.roomShard(roomID)
+	shard.mu.Lock()
+	shard.rooms[roomID] = room
+	shard.mu.Unlock()
 
-	h.roomsMu.Lock()
-	h.rooms[roomID] = room
-	h.roomsMu.Unlock()
+	h.scheduleIdleClose(room)
 
-	log.Printf("Room created: %s (%s)", roomID, config.Name)
+	h.logger.Info("room created", "room_id", roomID, "name", config.Name)
+
+	// Call onRoomCreated hooks
+	h.emitRoomCreated(room)
 
 	// Cache room metadata if cache is available
 	if h.cache != nil {
@@ -38,37 +38,107 @@ func (h *Hub) CreateRoom(config *RoomConfig) string {
 
 // CreateRoomWithID creates a new room with a specific ID
 func (h *Hub) CreateRoomWithID(roomID string, config *RoomConfig) error {
-	h.roomsMu.Lock()
-	defer h.roomsMu.Unlock()
+	shard := h.roomShard(roomID)
+	shard.mu.Lock()
 
 	// Check if room already exists
-	if _, exists := h.rooms[roomID]; exists {
+	if _, exists := shard.rooms[roomID]; exists {
+		shard.mu.Unlock()
 		return errors.New("room already exists")
 	}
 
-	room := &Room{
-		ID:         roomID,
-		Name:       config.Name,
-		Clients:    make(map[string]*Client),
-		MaxClients: config.MaxClients,
-		IsPrivate:  config.IsPrivate,
-		Password:   config.Password,
-		CreatedAt:  time.Now(),
-		Metadata:   config.Metadata,
-	}
+	room := newRoom(roomID, config)
+	shard.rooms[roomID] = room
+	shard.mu.Unlock()
 
-	h.rooms[roomID] = room
+	h.scheduleIdleClose(room)
 
-	log.Printf("Room created with custom ID: %s (%s)", roomID, config.Name)
+	h.logger.Info("room created", "room_id", roomID, "name", config.Name)
+
+	// Call onRoomCreated hooks
+	h.emitRoomCreated(room)
 
 	return nil
 }
 
+// defaultIdleTimeout applies to CloseAfterIdle rooms without an IdleTimeout
+const defaultIdleTimeout = 5 * time.Minute
+
+// newRoom builds a Room from its config
+func newRoom(roomID string, config *RoomConfig) *Room {
+	idleTimeout := config.IdleTimeout
+	if config.Lifecycle == CloseAfterIdle && idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	return &Room{
+		ID:          roomID,
+		Name:        config.Name,
+		Clients:     make(map[string]*Client),
+		members:     make(map[string]*RoomMember),
+		signals:     make(map[string]*roomSignal),
+		signalSent:  make(map[string]time.Time),
+		MaxClients:  config.MaxClients,
+		IsPrivate:   config.IsPrivate,
+		Password:    config.Password,
+		CreatedAt:   time.Now(),
+		Metadata:    config.Metadata,
+		Lifecycle:   config.Lifecycle,
+		IdleTimeout: idleTimeout,
+		History:     config.History,
+	}
+}
+
+// scheduleIdleClose starts the idle timer for an empty CloseAfterIdle room
+func (h *Hub) scheduleIdleClose(room *Room) {
+	if room.Lifecycle != CloseAfterIdle {
+		return
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if len(room.Clients) > 0 || room.idleTimer != nil {
+		return
+	}
+
+	room.idleTimer = h.clock.AfterFunc(room.IdleTimeout, func() {
+		h.closeIdleRoom(room)
+	})
+}
+
+// cancelIdleClose stops a pending idle timer. Caller must hold room.mu.
+func (r *Room) cancelIdleClose() {
+	if r.idleTimer != nil {
+		r.idleTimer.Stop()
+		r.idleTimer = nil
+	}
+}
+
+// closeIdleRoom closes the room if it is still registered and still empty
+func (h *Hub) closeIdleRoom(room *Room) {
+	if h.GetRoom(room.ID) != room {
+		return
+	}
+
+	room.mu.Lock()
+	room.idleTimer = nil
+	empty := len(room.Clients) == 0
+	room.mu.Unlock()
+
+	if empty {
+		h.CloseRoomWithReason(room.ID, CloseReasonIdle)
+	}
+}
+
 // JoinRoom adds a client to a room
 func (h *Hub) JoinRoom(userID, roomID string) error {
-	h.roomsMu.Lock()
-	room, exists := h.rooms[roomID]
-	h.roomsMu.Unlock()
+	return h.JoinRoomWithMember(userID, roomID, nil)
+}
+
+// JoinRoomWithMember adds a client to a room with per-room member metadata
+func (h *Hub) JoinRoomWithMember(userID, roomID string, member *RoomMember) error {
+	room, exists := h.findRoom(roomID)
 
 	if !exists {
 		return errors.New("room not found")
@@ -79,41 +149,80 @@ func (h *Hub) JoinRoom(userID, roomID string) error {
 		return errors.New("room is full")
 	}
 
-	h.clientsMu.RLock()
-	client, clientExists := h.clients[userID]
-	h.clientsMu.RUnlock()
+	client, clientExists := h.findClient(userID)
 
 	if !clientExists {
 		return errors.New("client not connected")
 	}
 
-	// Add client to room
+	// Call onBeforeJoin hooks, any of which may veto the join
+	if err := h.emitBeforeJoin(client, room); err != nil {
+		client.SendMessage(Message{
+			Type: "join_denied",
+			Data: map[string]interface{}{
+				"room_id": roomID,
+				"reason":  err.Error(),
+			},
+		})
+		return err
+	}
+
+	info := RoomMember{}
+	if member != nil {
+		info = *member
+	}
+	info.UserID = userID
+	info.JoinedAt = h.clock.Now()
+
+	// Add client to room, unless it was closed or filled up meanwhile
 	room.mu.Lock()
+	if room.closed {
+		room.mu.Unlock()
+		return errors.New("room not found")
+	}
+	if _, rejoin := room.Clients[userID]; !rejoin && room.MaxClients > 0 && len(room.Clients) >= room.MaxClients {
+		room.mu.Unlock()
+		return errors.New("room is full")
+	}
 	room.Clients[userID] = client
+	room.members[userID] = &info
+	room.cancelIdleClose()
+
+	// Add room to client's room list while the room can't be closed or left
+	client.addRoom(roomID)
 	room.mu.Unlock()
 
-	// Add room to client's room list
-	client.Rooms[roomID] = true
+	h.logger.Debug("user joined room", "user_id", userID, "client_id", client.ID, "room_id", roomID)
 
-	log.Printf("User %s joined room %s", userID, roomID)
+	// Send the member list and recent history to the new member
+	client.SendMessage(Message{
+		Type: "room.members",
+		Data: map[string]interface{}{
+			"room_id": roomID,
+			"members": room.memberList(),
+		},
+	})
+	h.replayHistory(client, room)
 
 	// Notify other room members
-	h.BroadcastToRoom(roomID, Message{
+	h.broadcastToRoom(room, Message{
 		Type: "user_joined",
 		Data: map[string]interface{}{
 			"user_id": userID,
 			"room_id": roomID,
+			"member":  info,
 		},
 	})
 
+	// Call onJoined hooks
+	h.emitJoined(client, room)
+
 	return nil
 }
 
 // LeaveRoom removes a client from a room
 func (h *Hub) LeaveRoom(userID, roomID string) error {
-	h.roomsMu.RLock()
-	room, exists := h.rooms[roomID]
-	h.roomsMu.RUnlock()
+	room, exists := h.findRoom(roomID)
 
 	if !exists {
 		return errors.New("room not found")
@@ -121,27 +230,41 @@ func (h *Hub) LeaveRoom(userID, roomID string) error {
 
 	// Remove client from room
 	room.mu.Lock()
+	client, wasMember := room.Clients[userID]
 	delete(room.Clients, userID)
+	delete(room.members, userID)
 	clientCount := len(room.Clients)
-	room.mu.Unlock()
 
 	// Remove room from client's list
-	h.clientsMu.RLock()
-	if client, ok := h.clients[userID]; ok {
-		delete(client.Rooms, roomID)
+	if wasMember {
+		client.removeRoom(roomID)
 	}
-	h.clientsMu.RUnlock()
+	room.mu.Unlock()
 
-	log.Printf("User %s left room %s", userID, roomID)
+	h.logger.Debug("user left room", "user_id", userID, "room_id", roomID)
+
+	// Typing indicators and other signals end with the membership
+	h.clearUserSignals(room, userID)
+
+	// Call onLeft hooks
+	if wasMember {
+		h.emitLeft(userID, room)
+	}
 
-	// If room is empty, close it
+	// Apply the room's lifecycle policy once it is empty
 	if clientCount == 0 {
-		h.CloseRoom(roomID)
+		switch room.Lifecycle {
+		case Persistent:
+		case CloseAfterIdle:
+			h.scheduleIdleClose(room)
+		default:
+			h.CloseRoomWithReason(roomID, CloseReasonEmpty)
+		}
 		return nil
 	}
 
 	// Notify remaining members
-	h.BroadcastToRoom(roomID, Message{
+	h.broadcastToRoom(room, Message{
 		Type: "user_left",
 		Data: map[string]interface{}{
 			"user_id": userID,
@@ -154,55 +277,72 @@ func (h *Hub) LeaveRoom(userID, roomID string) error {
 
 // LeaveAllRooms removes a client from all rooms
 func (h *Hub) LeaveAllRooms(userID string) {
-	h.clientsMu.RLock()
-	client, exists := h.clients[userID]
-	h.clientsMu.RUnlock()
+	client, exists := h.findClient(userID)
 
 	if !exists {
 		return
 	}
 
-	// Get list of rooms to leave
-	roomsToLeave := make([]string, 0, len(client.Rooms))
-	for roomID := range client.Rooms {
-		roomsToLeave = append(roomsToLeave, roomID)
-	}
+	h.leaveAllRooms(client)
+}
+
+// leaveAllRooms removes a client from every room in its room list. It also
+// works after the client has been removed from the hub.
+func (h *Hub) leaveAllRooms(client *Client) {
+	userID := client.UserID
 
 	// Leave each room
-	for _, roomID := range roomsToLeave {
+	for _, roomID := range client.RoomList() {
 		h.LeaveRoom(userID, roomID)
 	}
 }
 
 // CloseRoom closes a room and removes all clients
 func (h *Hub) CloseRoom(roomID string) {
-	h.roomsMu.Lock()
-	room, exists := h.rooms[roomID]
+	h.CloseRoomWithReason(roomID, CloseReasonManual)
+}
+
+// CloseRoomWithReason closes a room and passes the reason to its clients
+// and to the onRoomClosed hook
+func (h *Hub) CloseRoomWithReason(roomID, reason string) {
+	shard := h.roomShard(roomID)
+	shard.mu.Lock()
+	room, exists := shard.rooms[roomID]
 	if !exists {
-		h.roomsMu.Unlock()
+		shard.mu.Unlock()
 		return
 	}
 
 	// Notify all clients in the room
-	room.mu.RLock()
-	for userID := range room.Clients {
-		if client := h.GetClient(userID); client != nil {
-			client.SendMessage(Message{
-				Type: "room_closed",
-				Data: map[string]interface{}{
-					"room_id": roomID,
-				},
-			})
-			delete(client.Rooms, roomID)
-		}
+	room.mu.Lock()
+	room.closed = true
+	room.cancelIdleClose()
+	room.stopSignals()
+	for _, client := range room.Clients {
+		client.SendMessage(Message{
+			Type: "room_closed",
+			Data: map[string]interface{}{
+				"room_id": roomID,
+				"reason":  reason,
+			},
+		})
+		client.removeRoom(roomID)
 	}
-	room.mu.RUnlock()
+	room.mu.Unlock()
 
 	// Delete room
-	delete(h.rooms, roomID)
-	h.roomsMu.Unlock()
+	delete(shard.rooms, roomID)
+	shard.mu.Unlock()
+
+	h.logger.Info("room closed", "room_id", roomID, "reason", reason)
+
+	if room.History != nil && !room.History.KeepOnClose {
+		h.store.Delete(roomID)
+		h.deleteReadCursors(roomID)
+	}
 
-	log.Printf("Room closed: %s", roomID)
+	// Call onRoomClosed hooks
+	h.emitRoomClosed(room, reason)
 
 	// Remove from cache if available
 	if h.cache != nil {
@@ -211,16 +351,28 @@ func (h *Hub) CloseRoom(roomID string) {
 	}
 }
 
-// BroadcastToRoom sends a message to all clients in a room
+// BroadcastToRoom sends a message to all clients in a room and records it
+// in the room's history when enabled
 func (h *Hub) BroadcastToRoom(roomID string, msg Message) {
-	h.roomsMu.RLock()
-	room, exists := h.rooms[roomID]
-	h.roomsMu.RUnlock()
+	room, exists := h.findRoom(roomID)
 
 	if !exists {
 		return
 	}
 
+	msg = h.recordHistory(room, msg)
+	h.broadcastToRoom(room, msg)
+}
+
+// broadcastToRoom sends a message to all clients in a room without
+// recording it, used for system notifications
+func (h *Hub) broadcastToRoom(room *Room, msg Message) {
+	start := time.Now()
+	_, span := h.startSpan(context.Background(), &msg, "websocket.broadcast", map[string]string{
+		"type":    msg.Type,
+		"room_id": room.ID,
+	})
+
 	room.mu.RLock()
 	defer room.mu.RUnlock()
 
@@ -228,32 +380,35 @@ func (h *Hub) BroadcastToRoom(roomID string, msg Message) {
 		client.SendMessage(msg)
 	}
 
+	if h.config.Metrics != nil {
+		h.config.Metrics.BroadcastCompleted(len(room.Clients), time.Since(start))
+	}
+	if span != nil {
+		span.SetAttribute("recipients", strconv.Itoa(len(room.Clients)))
+		span.End()
+	}
+
 	// If cache available, publish to other servers (distributed mode)
 	if h.cache != nil {
-		// h.cache.Publish("ws:room:"+roomID, msg)
+		// h.cache.Publish("ws:room:"+room.ID, msg)
 	}
 }
 
 // GetRoom returns a room by ID
 func (h *Hub) GetRoom(roomID string) *Room {
-	h.roomsMu.RLock()
-	defer h.roomsMu.RUnlock()
-	return h.rooms[roomID]
+	room, _ := h.findRoom(roomID)
+	return room
 }
 
 // RoomExists checks if a room exists
 func (h *Hub) RoomExists(roomID string) bool {
-	h.roomsMu.RLock()
-	defer h.roomsMu.RUnlock()
-	_, exists := h.rooms[roomID]
+	_, exists := h.findRoom(roomID)
 	return exists
 }
 
 // GetRoomClientCount returns the number of clients in a room
 func (h *Hub) GetRoomClientCount(roomID string) int {
-	h.roomsMu.RLock()
-	room, exists := h.rooms[roomID]
-	h.roomsMu.RUnlock()
+	room, exists := h.findRoom(roomID)
 
 	if !exists {
 		return 0
@@ -264,9 +419,7 @@ func (h *Hub) GetRoomClientCount(roomID string) int {
 
 // GetRoomClients returns the list of user IDs in a room
 func (h *Hub) GetRoomClients(roomID string) []string {
-	h.roomsMu.RLock()
-	room, exists := h.rooms[roomID]
-	h.roomsMu.RUnlock()
+	room, exists := h.findRoom(roomID)
 
 	if !exists {
 		return []string{}
@@ -284,45 +437,62 @@ func (h *Hub) GetRoomClients(roomID string) []string {
 
 // ListRooms returns all public rooms
 func (h *Hub) ListRooms() []*RoomInfo {
-	h.roomsMu.RLock()
-	defer h.roomsMu.RUnlock()
-
 	rooms := make([]*RoomInfo, 0)
-	for _, room := range h.rooms {
+	h.forEachRoom(func(room *Room) {
 		if !room.IsPrivate {
 			rooms = append(rooms, room.ToInfo())
 		}
-	}
+	})
+	return rooms
+}
+
+// ListAllRooms returns all rooms, including private ones
+func (h *Hub) ListAllRooms() []*RoomInfo {
+	rooms := make([]*RoomInfo, 0)
+	h.forEachRoom(func(room *Room) {
+		rooms = append(rooms, room.ToInfo())
+	})
 	return rooms
 }
 
 // GetUserRooms returns all rooms a user is in
 func (h *Hub) GetUserRooms(userID string) []string {
-	h.clientsMu.RLock()
-	client, exists := h.clients[userID]
-	h.clientsMu.RUnlock()
+	client, exists := h.findClient(userID)
 
 	if !exists {
 		return []string{}
 	}
 
-	rooms := make([]string, 0, len(client.Rooms))
-	for roomID := range client.Rooms {
-		rooms = append(rooms, roomID)
-	}
-	return rooms
+	return client.RoomList()
 }
 
-// KickFromRoom forcefully removes a user from a room
+// KickFromRoom forcefully removes a member from a room. It returns
+// ErrNotInRoom for users who are not in the room.
 func (h *Hub) KickFromRoom(userID, roomID, reason string) error {
-	// Send kick notification
-	if err := h.SendToUser(userID, Message{
-		Type: "kicked",
-		Data: map[string]interface{}{
-			"room_id": roomID,
-			"reason":  reason,
-		},
-	}); err == nil {
+	room := h.GetRoom(roomID)
+	if room == nil {
+		return errors.New("room not found")
+	}
+	room.mu.RLock()
+	_, member := room.Clients[userID]
+	room.mu.RUnlock()
+	if !member {
+		return ErrNotInRoom
+	}
+
+	// Call onKicked hooks
+	h.emitKicked(userID, room, reason)
+
+	// Send kick notification to a live connection only; a queued one would
+	// arrive about a room the user is no longer in
+	if client := h.GetClient(userID); client != nil {
+		client.SendMessage(Message{
+			Type: "kicked",
+			Data: map[string]interface{}{
+				"room_id": roomID,
+				"reason":  reason,
+			},
+		})
 		// Give client time to receive the message
 		time.Sleep(100 * time.Millisecond)
 	}
@@ -333,9 +503,7 @@ func (h *Hub) KickFromRoom(userID, roomID, reason string) error {
 
 // IsRoomFull checks if a room has reached max capacity
 func (h *Hub) IsRoomFull(roomID string) bool {
-	h.roomsMu.RLock()
-	room, exists := h.rooms[roomID]
-	h.roomsMu.RUnlock()
+	room, exists := h.findRoom(roomID)
 
 	if !exists {
 		return false
@@ -0,0 +1,103 @@
+package websocket
+
+import "sync"
+
+// defaultShards is the number of client and room shards when Config.Shards
+// is zero
+const defaultShards = 32
+
+// clientShard holds the clients of the users hashing to it
+type clientShard struct {
+	clients map[string]*Client
+	mu      sync.RWMutex
+}
+
+// roomShard holds the rooms whose IDs hash to it
+type roomShard struct {
+	rooms map[string]*Room
+	mu    sync.RWMutex
+}
+
+func newShards(n int) ([]*clientShard, []*roomShard) {
+	if n <= 0 {
+		n = defaultShards
+	}
+
+	clients := make([]*clientShard, n)
+	rooms := make([]*roomShard, n)
+	for i := 0; i < n; i++ {
+		clients[i] = &clientShard{clients: make(map[string]*Client)}
+		rooms[i] = &roomShard{rooms: make(map[string]*Room)}
+	}
+	return clients, rooms
+}
+
+// shardIndex hashes a key with FNV-1a
+func shardIndex(key string, n int) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return int(hash % uint32(n))
+}
+
+// clientShard returns the shard of a user
+func (h *Hub) clientShard(userID string) *clientShard {
+	return h.clientShards[shardIndex(userID, len(h.clientShards))]
+}
+
+// roomShard returns the shard of a room
+func (h *Hub) roomShard(roomID string) *roomShard {
+	return h.roomShards[shardIndex(roomID, len(h.roomShards))]
+}
+
+// findClient returns the client of a user
+func (h *Hub) findClient(userID string) (*Client, bool) {
+	shard := h.clientShard(userID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	client, exists := shard.clients[userID]
+	return client, exists
+}
+
+// addClient stores a client as the connection of its user
+func (h *Hub) addClient(client *Client) {
+	shard := h.clientShard(client.UserID)
+	shard.mu.Lock()
+	shard.clients[client.UserID] = client
+	shard.mu.Unlock()
+}
+
+// findRoom returns a room by ID
+func (h *Hub) findRoom(roomID string) (*Room, bool) {
+	shard := h.roomShard(roomID)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	room, exists := shard.rooms[roomID]
+	return room, exists
+}
+
+// forEachClient calls fn for every connected client, one shard at a time.
+// fn must not register or unregister clients.
+func (h *Hub) forEachClient(fn func(*Client)) {
+	for _, shard := range h.clientShards {
+		shard.mu.RLock()
+		for _, client := range shard.clients {
+			fn(client)
+		}
+		shard.mu.RUnlock()
+	}
+}
+
+// forEachRoom calls fn for every room, one shard at a time. fn must not
+// create or close rooms.
+func (h *Hub) forEachRoom(fn func(*Room)) {
+	for _, shard := range h.roomShards {
+		shard.mu.RLock()
+		for _, room := range shard.rooms {
+			fn(room)
+		}
+		shard.mu.RUnlock()
+	}
+}
@@ -0,0 +1,192 @@
+package websocket
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Default ephemeral signal settings
+const (
+	defaultSignalTTL      = 5 * time.Second
+	defaultSignalThrottle = 1 * time.Second
+)
+
+// roomSignal is an active ephemeral signal such as "typing"
+type roomSignal struct {
+	timer Timer
+}
+
+// signalKey identifies a signal of one user in a room
+func signalKey(userID, signal string) string {
+	return userID + "\x00" + signal
+}
+
+// SendRoomSignal broadcasts an ephemeral signal (e.g. "typing") from a room
+// member. Signals are never stored in history and clear themselves after
+// Config.SignalTTL; repeating an active signal within Config.SignalThrottle
+// only extends its TTL.
+func (h *Hub) SendRoomSignal(roomID, userID, signal string, data map[string]interface{}) error {
+	room := h.GetRoom(roomID)
+	if room == nil {
+		return errors.New("room not found")
+	}
+	if signal == "" {
+		return errors.New("signal name required")
+	}
+
+	ttl, throttle := h.signalTimings()
+	key := signalKey(userID, signal)
+	now := h.clock.Now()
+
+	room.mu.Lock()
+	if _, ok := room.Clients[userID]; !ok {
+		room.mu.Unlock()
+		return errors.New("user not in room")
+	}
+
+	active, isActive := room.signals[key]
+	throttled := now.Sub(room.signalSent[key]) < throttle
+	if throttled && !isActive {
+		room.mu.Unlock()
+		return errors.New("signal throttled")
+	}
+
+	if isActive {
+		active.timer.Stop()
+	}
+	current := &roomSignal{}
+	current.timer = h.clock.AfterFunc(ttl, func() {
+		h.expireRoomSignal(room, key, current, userID, signal)
+	})
+	room.signals[key] = current
+
+	if throttled {
+		// Still active: refresh the TTL without another broadcast
+		room.mu.Unlock()
+		return nil
+	}
+	room.signalSent[key] = now
+	room.mu.Unlock()
+
+	h.broadcastToRoom(room, signalMessage(roomID, userID, signal, true, data, ttl))
+	return nil
+}
+
+// ClearRoomSignal clears an active signal and notifies the room
+func (h *Hub) ClearRoomSignal(roomID, userID, signal string) {
+	room := h.GetRoom(roomID)
+	if room == nil {
+		return
+	}
+
+	key := signalKey(userID, signal)
+
+	room.mu.Lock()
+	active, ok := room.signals[key]
+	if ok {
+		active.timer.Stop()
+		delete(room.signals, key)
+	}
+	room.mu.Unlock()
+
+	if ok {
+		h.broadcastToRoom(room, signalMessage(roomID, userID, signal, false, nil, 0))
+	}
+}
+
+// expireRoomSignal clears a signal whose TTL ran out, unless it was
+// refreshed or cleared in the meantime
+func (h *Hub) expireRoomSignal(room *Room, key string, expired *roomSignal, userID, signal string) {
+	room.mu.Lock()
+	if room.signals[key] != expired {
+		room.mu.Unlock()
+		return
+	}
+	delete(room.signals, key)
+	room.mu.Unlock()
+
+	h.broadcastToRoom(room, signalMessage(room.ID, userID, signal, false, nil, 0))
+}
+
+// clearUserSignals drops all signals of a user leaving the room and tells
+// the remaining members
+func (h *Hub) clearUserSignals(room *Room, userID string) {
+	prefix := signalKey(userID, "")
+
+	room.mu.Lock()
+	cleared := make([]string, 0)
+	for key, active := range room.signals {
+		if strings.HasPrefix(key, prefix) {
+			active.timer.Stop()
+			delete(room.signals, key)
+			cleared = append(cleared, strings.TrimPrefix(key, prefix))
+		}
+	}
+	for key := range room.signalSent {
+		if strings.HasPrefix(key, prefix) {
+			delete(room.signalSent, key)
+		}
+	}
+	room.mu.Unlock()
+
+	for _, signal := range cleared {
+		h.broadcastToRoom(room, signalMessage(room.ID, userID, signal, false, nil, 0))
+	}
+}
+
+// stopSignals cancels all signal timers of a closing room. Caller must hold room.mu.
+func (r *Room) stopSignals() {
+	for key, active := range r.signals {
+		active.timer.Stop()
+		delete(r.signals, key)
+	}
+}
+
+// handleSignalMessage handles room.signal messages sent by clients
+func (h *Hub) handleSignalMessage(client *Client, msg Message) {
+	roomID, _ := msg.Data["room_id"].(string)
+	signal, _ := msg.Data["signal"].(string)
+
+	if active, ok := msg.Data["active"].(bool); ok && !active {
+		h.ClearRoomSignal(roomID, client.UserID, signal)
+		return
+	}
+
+	data, _ := msg.Data["data"].(map[string]interface{})
+	if err := h.SendRoomSignal(roomID, client.UserID, signal, data); err != nil {
+		client.SendMessage(errorMessage(msg.Type, err))
+	}
+}
+
+// signalTimings returns the configured TTL and throttle, or the defaults
+func (h *Hub) signalTimings() (time.Duration, time.Duration) {
+	ttl, throttle := h.config.SignalTTL, h.config.SignalThrottle
+	if ttl <= 0 {
+		ttl = defaultSignalTTL
+	}
+	if throttle <= 0 {
+		throttle = defaultSignalThrottle
+	}
+	return ttl, throttle
+}
+
+// signalMessage builds a room.signal message
+func signalMessage(roomID, userID, signal string, active bool, data map[string]interface{}, ttl time.Duration) Message {
+	msg := Message{
+		Type: "room.signal",
+		Data: map[string]interface{}{
+			"room_id": roomID,
+			"user_id": userID,
+			"signal":  signal,
+			"active":  active,
+		},
+	}
+	if active {
+		msg.Data["ttl_ms"] = ttl.Milliseconds()
+		if data != nil {
+			msg.Data["data"] = data
+		}
+	}
+	return msg
+}
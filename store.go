@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is a message recorded by a MessageStore or OfflineStore.
+// RoomID is set for room history, From for offline messages.
+type HistoryEntry struct {
+	ID        int64     `json:"id"`
+	RoomID    string    `json:"room_id,omitempty"`
+	From      string    `json:"from,omitempty"`
+	Message   Message   `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MessageStore persists room history. IDs are assigned by the store,
+// increase monotonically per room and are copied into Message.ID.
+type MessageStore interface {
+	// Append records a message and returns the stored entry
+	Append(roomID string, msg Message) (HistoryEntry, error)
+	// History returns up to limit entries with ID < before, oldest first.
+	// before <= 0 means "from the latest entry".
+	History(roomID string, before int64, limit int) ([]HistoryEntry, error)
+	// Since returns all entries with ID > after, oldest first
+	Since(roomID string, after int64) ([]HistoryEntry, error)
+	// Trim drops entries beyond maxMessages or older than maxAge (0 = no limit)
+	Trim(roomID string, maxMessages int, maxAge time.Duration) error
+	// Delete removes all history for a room
+	Delete(roomID string) error
+}
+
+// OfflineStore persists messages for users that are not connected.
+// IDs increase monotonically per user.
+type OfflineStore interface {
+	// Enqueue stores a message for a user. from is the sending user, if any.
+	Enqueue(userID, from string, msg Message) (HistoryEntry, error)
+	// Pending returns all stored messages for a user, oldest first
+	Pending(userID string) ([]HistoryEntry, error)
+	// Ack removes messages with ID <= upTo
+	Ack(userID string, upTo int64) error
+	// TrimPending drops messages beyond maxMessages or older than maxAge (0 = no limit)
+	TrimPending(userID string, maxMessages int, maxAge time.Duration) error
+}
+
+// Key prefixes separating room history from offline inboxes
+const (
+	roomKeyPrefix  = "room:"
+	inboxKeyPrefix = "inbox:"
+)
+
+// MemoryStore is an in-process MessageStore and OfflineStore, used when
+// no store is configured
+type MemoryStore struct {
+	logs logSet
+	mu   sync.Mutex
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		logs: make(logSet),
+	}
+}
+
+// Append records a message for a room
+func (s *MemoryStore) Append(roomID string, msg Message) (HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logs.append(roomID, msg), nil
+}
+
+// History returns a page of entries older than before
+func (s *MemoryStore) History(roomID string, before int64, limit int) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logs.page(roomKeyPrefix+roomID, before, limit), nil
+}
+
+// Since returns the entries newer than after
+func (s *MemoryStore) Since(roomID string, after int64) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logs.since(roomKeyPrefix+roomID, after), nil
+}
+
+// Trim applies retention limits to a room's history
+func (s *MemoryStore) Trim(roomID string, maxMessages int, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs.dropBefore(roomKeyPrefix+roomID, s.logs.trimPoint(roomKeyPrefix+roomID, maxMessages, maxAge))
+	return nil
+}
+
+// Delete removes a room's history
+func (s *MemoryStore) Delete(roomID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.logs, roomKeyPrefix+roomID)
+	return nil
+}
+
+// Enqueue stores a message for an offline user
+func (s *MemoryStore) Enqueue(userID, from string, msg Message) (HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.logs.enqueue(userID, from, msg), nil
+}
+
+// Pending returns the stored messages for a user
+func (s *MemoryStore) Pending(userID string) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logs.page(inboxKeyPrefix+userID, 0, 0), nil
+}
+
+// Ack removes delivered messages for a user
+func (s *MemoryStore) Ack(userID string, upTo int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs.dropBefore(inboxKeyPrefix+userID, upTo+1)
+	return nil
+}
+
+// TrimPending applies retention limits to a user's offline messages
+func (s *MemoryStore) TrimPending(userID string, maxMessages int, maxAge time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs.dropBefore(inboxKeyPrefix+userID, s.logs.trimPoint(inboxKeyPrefix+userID, maxMessages, maxAge))
+	return nil
+}
+
+// entryLog is an ordered list of entries for one key
+type entryLog struct {
+	lastID  int64
+	entries []HistoryEntry
+}
+
+// logSet holds entry logs by key. It is not synchronized.
+type logSet map[string]*entryLog
+
+// get returns the log for a key, creating it if needed
+func (s logSet) get(key string) *entryLog {
+	l, ok := s[key]
+	if !ok {
+		l = &entryLog{}
+		s[key] = l
+	}
+	return l
+}
+
+// nextID returns the ID the next entry for the key will get
+func (s logSet) nextID(key string) int64 {
+	if l, ok := s[key]; ok {
+		return l.lastID + 1
+	}
+	return 1
+}
+
+// append records a room message under the next ID, copying the ID into
+// the message
+func (s logSet) append(roomID string, msg Message) HistoryEntry {
+	key := roomKeyPrefix + roomID
+	msg.ID = s.nextID(key)
+	entry := HistoryEntry{
+		ID:        msg.ID,
+		RoomID:    roomID,
+		Message:   msg,
+		CreatedAt: time.Now(),
+	}
+	s.insert(key, entry)
+	return entry
+}
+
+// enqueue records an offline message for a user under the next ID
+func (s logSet) enqueue(userID, from string, msg Message) HistoryEntry {
+	key := inboxKeyPrefix + userID
+	entry := HistoryEntry{
+		ID:        s.nextID(key),
+		From:      from,
+		Message:   msg,
+		CreatedAt: time.Now(),
+	}
+	s.insert(key, entry)
+	return entry
+}
+
+// insert adds an existing entry, ignoring IDs that were already seen
+func (s logSet) insert(key string, entry HistoryEntry) {
+	l := s.get(key)
+	if entry.ID <= l.lastID {
+		return
+	}
+	l.lastID = entry.ID
+	l.entries = append(l.entries, entry)
+}
+
+// since returns the entries with ID > after, oldest first
+func (s logSet) since(key string, after int64) []HistoryEntry {
+	l, ok := s[key]
+	if !ok {
+		return []HistoryEntry{}
+	}
+
+	start := sort.Search(len(l.entries), func(i int) bool {
+		return l.entries[i].ID > after
+	})
+	entries := make([]HistoryEntry, len(l.entries)-start)
+	copy(entries, l.entries[start:])
+	return entries
+}
+
+// page returns up to limit entries with ID < before, oldest first
+func (s logSet) page(key string, before int64, limit int) []HistoryEntry {
+	l, ok := s[key]
+	if !ok {
+		return []HistoryEntry{}
+	}
+
+	end := len(l.entries)
+	if before > 0 {
+		for end > 0 && l.entries[end-1].ID >= before {
+			end--
+		}
+	}
+
+	start := 0
+	if limit > 0 && end-limit > start {
+		start = end - limit
+	}
+
+	page := make([]HistoryEntry, end-start)
+	copy(page, l.entries[start:end])
+	return page
+}
+
+// trimPoint returns the lowest ID to keep under the given limits, or 0
+// if nothing needs to be dropped
+func (s logSet) trimPoint(key string, maxMessages int, maxAge time.Duration) int64 {
+	l, ok := s[key]
+	if !ok {
+		return 0
+	}
+
+	start := 0
+	if maxMessages > 0 && len(l.entries) > maxMessages {
+		start = len(l.entries) - maxMessages
+	}
+	if maxAge > 0 {
+		cutoff := time.Now().Add(-maxAge)
+		for start < len(l.entries) && l.entries[start].CreatedAt.Before(cutoff) {
+			start++
+		}
+	}
+
+	if start == 0 {
+		return 0
+	}
+	if start == len(l.entries) {
+		return l.lastID + 1
+	}
+	return l.entries[start].ID
+}
+
+// dropBefore removes entries with ID < id
+func (s logSet) dropBefore(key string, id int64) {
+	l, ok := s[key]
+	if !ok || id <= 0 {
+		return
+	}
+
+	start := 0
+	for start < len(l.entries) && l.entries[start].ID < id {
+		start++
+	}
+	if start == 0 {
+		return
+	}
+
+	kept := make([]HistoryEntry, len(l.entries)-start)
+	copy(kept, l.entries[start:])
+	l.entries = kept
+}
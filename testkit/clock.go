@@ -0,0 +1,143 @@
+package testkit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+// FakeClock is a websocket.Clock that only moves when advanced. Pass it as
+// Config.Clock.
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	added  chan struct{}
+	mu     sync.Mutex
+}
+
+// NewFakeClock creates a clock set to start, or to a fixed date if start
+// is zero
+func NewFakeClock(start time.Time) *FakeClock {
+	if start.IsZero() {
+		start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return &FakeClock{
+		now:   start,
+		added: make(chan struct{}, 1),
+	}
+}
+
+// fakeTimer is a pending AfterFunc call or a ticker
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	period time.Duration // > 0 for tickers
+	fn     func()
+	c      chan time.Time
+}
+
+// Now returns the clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// AfterFunc calls f once the clock has been advanced by d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) websocket.Timer {
+	return c.add(&fakeTimer{clock: c, when: c.Now().Add(d), fn: f})
+}
+
+// NewTicker ticks every d of advanced time
+func (c *FakeClock) NewTicker(d time.Duration) websocket.Ticker {
+	if d <= 0 {
+		panic("testkit: non-positive ticker interval")
+	}
+	return fakeTicker{c.add(&fakeTimer{clock: c, when: c.Now().Add(d), period: d, c: make(chan time.Time, 1)})}
+}
+
+func (c *FakeClock) add(t *fakeTimer) *fakeTimer {
+	c.mu.Lock()
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+
+	select {
+	case c.added <- struct{}{}:
+	default:
+	}
+	return t
+}
+
+// Advance moves the clock forward, firing due timers and tickers in order.
+// AfterFunc callbacks run on the calling goroutine.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+		if len(c.timers) == 0 || c.timers[0].when.After(target) {
+			break
+		}
+
+		t := c.timers[0]
+		c.now = t.when
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+			select {
+			case t.c <- c.now:
+			default:
+				// Like time.Ticker, drop ticks for slow receivers
+			}
+			continue
+		}
+
+		c.timers = c.timers[1:]
+		c.mu.Unlock()
+		t.fn()
+		c.mu.Lock()
+	}
+
+	c.now = target
+	c.mu.Unlock()
+}
+
+// BlockUntil waits until at least n timers and tickers are pending, e.g.
+// for a client's ping ticker, which is created on its own goroutine
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		pending := len(c.timers)
+		c.mu.Unlock()
+		if pending >= n {
+			return
+		}
+		<-c.added
+	}
+}
+
+// Stop cancels the timer or ticker. It reports whether it was pending.
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTicker adapts a periodic fakeTimer to websocket.Ticker
+type fakeTicker struct {
+	timer *fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.timer.c }
+
+func (t fakeTicker) Stop() { t.timer.Stop() }
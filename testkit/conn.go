@@ -0,0 +1,286 @@
+// Package testkit runs Hub logic end to end without sockets. Connect
+// serves an in-memory connection on a hub and returns its test side, which
+// sends messages as the browser would and asserts on what the hub writes
+// back. FakeClock drives pings, idle timeouts, signal TTLs and presence
+// debouncing without sleeping.
+//
+//	hub := testkit.NewHub(nil)
+//	alice := testkit.Connect(hub, "alice")
+//	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Lobby"})
+//	hub.JoinRoom("alice", roomID)
+//	if _, err := alice.ExpectMessage("room.members", time.Second); err != nil {
+//		t.Fatal(err)
+//	}
+package testkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+// ErrClosed is returned once the connection is closed by either side
+var ErrClosed = errors.New("connection closed")
+
+// NewHub creates a hub and starts its main loop, which handles
+// disconnects
+func NewHub(config *websocket.Config) *websocket.Hub {
+	hub := websocket.NewHub(config)
+	go hub.Run()
+	return hub
+}
+
+// Conn is the test side of an in-memory connection
+type Conn struct {
+	Client *websocket.Client
+	pipe   *pipe
+}
+
+// Connect serves an in-memory connection for userID on hub and returns
+// once the client is registered. The hub's Run loop must be running for
+// disconnects to be processed; NewHub starts it.
+func Connect(hub *websocket.Hub, userID string) *Conn {
+	return ConnectContext(context.Background(), hub, userID)
+}
+
+// ConnectContext is Connect with a connection context, as an upgrade
+// request would provide
+func ConnectContext(ctx context.Context, hub *websocket.Hub, userID string) *Conn {
+	p := newPipe()
+	return &Conn{
+		Client: hub.ServeConn(ctx, p, userID),
+		pipe:   p,
+	}
+}
+
+// Send sends a message to the hub as the client
+func (c *Conn) Send(msg websocket.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.SendRaw(data)
+}
+
+// SendRaw sends a raw text frame to the hub
+func (c *Conn) SendRaw(data []byte) error {
+	select {
+	case c.pipe.in <- data:
+		return nil
+	case <-c.pipe.closed:
+		return ErrClosed
+	}
+}
+
+// Next returns the next message the hub wrote
+func (c *Conn) Next(timeout time.Duration) (websocket.Message, error) {
+	var msg websocket.Message
+
+	data, err := c.pipe.next(timeout)
+	if err != nil {
+		return msg, err
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, fmt.Errorf("invalid message from hub: %w", err)
+	}
+	return msg, nil
+}
+
+// ExpectMessage returns the next message of the given type, skipping
+// others such as user_joined or room.members
+func (c *Conn) ExpectMessage(msgType string, timeout time.Duration) (websocket.Message, error) {
+	deadline := time.Now().Add(timeout)
+
+	var skipped []string
+	for {
+		msg, err := c.Next(time.Until(deadline))
+		if err != nil {
+			if len(skipped) > 0 {
+				return msg, fmt.Errorf("no %s message: %w (got %s)", msgType, err, strings.Join(skipped, ", "))
+			}
+			return msg, fmt.Errorf("no %s message: %w", msgType, err)
+		}
+		if msg.Type == msgType {
+			return msg, nil
+		}
+		skipped = append(skipped, msg.Type)
+	}
+}
+
+// ExpectNoMessage returns an error if the hub writes a message within
+// the timeout
+func (c *Conn) ExpectNoMessage(timeout time.Duration) error {
+	msg, err := c.Next(timeout)
+	if err == nil {
+		return fmt.Errorf("unexpected %s message", msg.Type)
+	}
+	if errors.Is(err, ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Drain returns the messages already written by the hub
+func (c *Conn) Drain() []websocket.Message {
+	var msgs []websocket.Message
+	for _, data := range c.pipe.drain() {
+		var msg websocket.Message
+		if json.Unmarshal(data, &msg) == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Pings returns how many pings the hub has sent
+func (c *Conn) Pings() int {
+	c.pipe.pongMu.Lock()
+	defer c.pipe.pongMu.Unlock()
+	return c.pipe.pings
+}
+
+// Close disconnects as the client would
+func (c *Conn) Close() {
+	c.pipe.Close()
+}
+
+// Closed is closed once either side closed the connection
+func (c *Conn) Closed() <-chan struct{} {
+	return c.pipe.closed
+}
+
+// pipe is the hub side of an in-memory connection. It implements
+// websocket.Conn; deadlines and read limits are not enforced.
+type pipe struct {
+	in chan []byte
+
+	out    [][]byte
+	notify chan struct{}
+	mu     sync.Mutex
+
+	pong      func(appData string) error
+	pings     int
+	pongMu    sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipe() *pipe {
+	return &pipe{
+		in:     make(chan []byte),
+		notify: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// ReadMessage returns the next frame sent by the test side
+func (p *pipe) ReadMessage() (int, []byte, error) {
+	select {
+	case data := <-p.in:
+		return websocket.TextMessage, data, nil
+	case <-p.closed:
+		return 0, nil, ErrClosed
+	}
+}
+
+// WriteMessage queues data frames for the test side. A close frame closes
+// the pipe and pings are answered right away, as a browser would.
+func (p *pipe) WriteMessage(messageType int, data []byte) error {
+	select {
+	case <-p.closed:
+		return ErrClosed
+	default:
+	}
+
+	switch messageType {
+	case websocket.CloseMessage:
+		p.Close()
+	case websocket.PingMessage:
+		p.pongMu.Lock()
+		pong := p.pong
+		p.pings++
+		p.pongMu.Unlock()
+		if pong != nil {
+			return pong(string(data))
+		}
+	default:
+		p.mu.Lock()
+		p.out = append(p.out, append([]byte(nil), data...))
+		p.mu.Unlock()
+
+		select {
+		case p.notify <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// next waits for a frame written by the hub
+func (p *pipe) next(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		p.mu.Lock()
+		if len(p.out) > 0 {
+			data := p.out[0]
+			p.out = p.out[1:]
+			p.mu.Unlock()
+			return data, nil
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-p.notify:
+		case <-p.closed:
+			// Frames written before the close are still delivered
+			p.mu.Lock()
+			pending := len(p.out)
+			p.mu.Unlock()
+			if pending == 0 {
+				return nil, ErrClosed
+			}
+		case <-timer.C:
+			return nil, fmt.Errorf("timed out after %v", timeout)
+		}
+	}
+}
+
+// drain removes and returns the queued frames
+func (p *pipe) drain() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := p.out
+	p.out = nil
+	return out
+}
+
+func (p *pipe) SetPongHandler(h func(appData string) error) {
+	p.pongMu.Lock()
+	defer p.pongMu.Unlock()
+	p.pong = h
+}
+
+func (p *pipe) SetReadDeadline(t time.Time) error  { return nil }
+func (p *pipe) SetWriteDeadline(t time.Time) error { return nil }
+func (p *pipe) SetReadLimit(limit int64)           {}
+func (p *pipe) RemoteAddr() net.Addr               { return pipeAddr{} }
+
+func (p *pipe) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return nil
+}
+
+// pipeAddr is the address of an in-memory connection
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
@@ -0,0 +1,155 @@
+package testkit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+func TestJoinBroadcastKick(t *testing.T) {
+	hub := NewHub(nil)
+	hub.OnMessage(func(ctx context.Context, c *websocket.Client, msg websocket.Message) {
+		if msg.Type == "chat" {
+			roomID, _ := msg.Data["room_id"].(string)
+			hub.BroadcastToRoom(roomID, msg)
+		}
+	})
+
+	alice := Connect(hub, "alice")
+	bob := Connect(hub, "bob")
+
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Lobby"})
+	hub.JoinRoom("alice", roomID)
+	hub.JoinRoom("bob", roomID)
+	if _, err := alice.ExpectMessage("user_joined", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	alice.Send(websocket.Message{Type: "chat", Data: map[string]interface{}{"room_id": roomID, "text": "hi"}})
+	msg, err := bob.ExpectMessage("chat", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Data["text"] != "hi" {
+		t.Errorf("Expected chat text, got %v", msg.Data)
+	}
+
+	hub.KickFromRoom("bob", roomID, "spam")
+	if msg, err := bob.ExpectMessage("kicked", time.Second); err != nil || msg.Data["reason"] != "spam" {
+		t.Fatalf("Expected kicked with reason, got %v %v", msg, err)
+	}
+	if _, err := alice.ExpectMessage("user_left", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if hub.GetRoomClientCount(roomID) != 1 {
+		t.Errorf("Expected 1 client left, got %d", hub.GetRoomClientCount(roomID))
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	hub := NewHub(nil)
+
+	left := make(chan string, 1)
+	hub.OnLeft(func(userID string, room *websocket.Room) { left <- userID })
+
+	alice := Connect(hub, "alice")
+	bob := Connect(hub, "bob")
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Lobby"})
+	hub.JoinRoom("alice", roomID)
+	hub.JoinRoom("bob", roomID)
+
+	// Client going away
+	alice.Close()
+	if userID := <-left; userID != "alice" {
+		t.Errorf("Expected alice to leave, got %s", userID)
+	}
+	if _, err := bob.ExpectMessage("user_left", time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Server side disconnect
+	hub.DisconnectUser("bob")
+	select {
+	case <-bob.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("Expected connection to be closed")
+	}
+	if hub.GetOnlineCount() != 0 {
+		t.Errorf("Expected no clients, got %d", hub.GetOnlineCount())
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	hub := NewHub(&websocket.Config{
+		Clock:        clock,
+		PingInterval: 10 * time.Second,
+	})
+
+	// Idle rooms close after the fake timeout
+	Connect(hub, "alice")
+	roomID := hub.CreateRoom(&websocket.RoomConfig{
+		Name:        "Match",
+		Lifecycle:   websocket.CloseAfterIdle,
+		IdleTimeout: time.Minute,
+	})
+	hub.JoinRoom("alice", roomID)
+	hub.LeaveRoom("alice", roomID)
+
+	clock.Advance(59 * time.Second)
+	if !hub.RoomExists(roomID) {
+		t.Fatal("Expected room to survive before the timeout")
+	}
+	clock.Advance(time.Second)
+	if hub.RoomExists(roomID) {
+		t.Fatal("Expected room to close after the timeout")
+	}
+
+	// Pings go out on the fake ticker and are answered by the pipe
+	bob := Connect(hub, "bob")
+	clock.BlockUntil(2)
+	clock.Advance(10 * time.Second)
+	for i := 0; i < 100 && bob.Pings() == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if bob.Pings() != 1 {
+		t.Errorf("Expected one ping, got %d", bob.Pings())
+	}
+	if err := bob.ExpectNoMessage(20 * time.Millisecond); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFakeClockTimers(t *testing.T) {
+	clock := NewFakeClock(time.Time{})
+	start := clock.Now()
+
+	var fired []time.Duration
+	clock.AfterFunc(3*time.Second, func() { fired = append(fired, clock.Now().Sub(start)) })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, clock.Now().Sub(start)) })
+	stopped := clock.AfterFunc(2*time.Second, func() { t.Error("Expected stopped timer not to fire") })
+	if !stopped.Stop() {
+		t.Error("Expected Stop to report a pending timer")
+	}
+
+	ticker := clock.NewTicker(2 * time.Second)
+	clock.Advance(5 * time.Second)
+
+	if len(fired) != 2 || fired[0] != time.Second || fired[1] != 3*time.Second {
+		t.Errorf("Expected timers at 1s and 3s, got %v", fired)
+	}
+	select {
+	case tick := <-ticker.C():
+		if tick.Sub(start) != 2*time.Second {
+			t.Errorf("Expected first tick at 2s, got %v", tick.Sub(start))
+		}
+	default:
+		t.Error("Expected a tick")
+	}
+	if clock.Now().Sub(start) != 5*time.Second {
+		t.Errorf("Expected clock at 5s, got %v", clock.Now().Sub(start))
+	}
+	ticker.Stop()
+}
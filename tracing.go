@@ -0,0 +1,51 @@
+package websocket
+
+import "context"
+
+// Tracer creates spans for message handling and broadcasts. It mirrors the
+// parts of the OpenTelemetry API the hub needs, so an adapter is a few lines;
+// the tracing subpackage has an in-memory implementation for tests.
+type Tracer interface {
+	// Start begins a span as a child of the span in ctx, if any
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span)
+	// Inject writes the span context of ctx into a carrier
+	Inject(ctx context.Context, carrier map[string]string)
+	// Extract returns a context holding the span context found in a carrier
+	Extract(ctx context.Context, carrier map[string]string) context.Context
+}
+
+// Span is an in-progress trace span
+type Span interface {
+	SetAttribute(key, value string)
+	RecordError(err error)
+	End()
+}
+
+// ContinueTrace returns a copy of m carrying the trace context of parent, so
+// a message built in an onMessage handler is correlated with the request
+func (m Message) ContinueTrace(parent Message) Message {
+	if parent.Trace != nil {
+		m.Trace = make(map[string]string, len(parent.Trace))
+		for k, v := range parent.Trace {
+			m.Trace[k] = v
+		}
+	}
+	return m
+}
+
+// startSpan starts a span continuing the trace carried by msg and replaces
+// msg.Trace with the new span's context. It returns ctx and a nil span
+// without a tracer.
+func (h *Hub) startSpan(ctx context.Context, msg *Message, name string, attrs map[string]string) (context.Context, Span) {
+	tracer := h.config.Tracer
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	ctx = tracer.Extract(ctx, msg.Trace)
+	ctx, span := tracer.Start(ctx, name, attrs)
+
+	msg.Trace = make(map[string]string)
+	tracer.Inject(ctx, msg.Trace)
+	return ctx, span
+}
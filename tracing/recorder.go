@@ -0,0 +1,158 @@
+// Package tracing provides an in-memory websocket.Tracer that records
+// finished spans, for tests and local debugging. Trace context is carried
+// in the W3C traceparent format.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+// TraceParentKey is the carrier key for the W3C trace context
+const TraceParentKey = "traceparent"
+
+// SpanData is a finished span
+type SpanData struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string // empty for root spans
+	Attributes map[string]string
+	Err        error
+	Start      time.Time
+	End        time.Time
+}
+
+// Recorder is a websocket.Tracer that keeps finished spans in memory
+type Recorder struct {
+	spans []SpanData
+	mu    sync.Mutex
+}
+
+// NewRecorder creates an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// spanContext identifies a span within a trace
+type spanContext struct {
+	traceID string
+	spanID  string
+}
+
+type contextKey struct{}
+
+// Start begins a span as a child of the span in ctx, if any
+func (r *Recorder) Start(ctx context.Context, name string, attrs map[string]string) (context.Context, websocket.Span) {
+	parent, _ := ctx.Value(contextKey{}).(spanContext)
+
+	sc := spanContext{traceID: parent.traceID, spanID: randomHex(8)}
+	if sc.traceID == "" {
+		sc.traceID = randomHex(16)
+	}
+
+	span := &recordedSpan{
+		recorder: r,
+		data: SpanData{
+			Name:       name,
+			TraceID:    sc.traceID,
+			SpanID:     sc.spanID,
+			ParentID:   parent.spanID,
+			Attributes: make(map[string]string, len(attrs)),
+			Start:      time.Now(),
+		},
+	}
+	for k, v := range attrs {
+		span.data.Attributes[k] = v
+	}
+
+	return context.WithValue(ctx, contextKey{}, sc), span
+}
+
+// Inject writes the span context of ctx as a traceparent
+func (r *Recorder) Inject(ctx context.Context, carrier map[string]string) {
+	sc, ok := ctx.Value(contextKey{}).(spanContext)
+	if !ok {
+		return
+	}
+	carrier[TraceParentKey] = fmt.Sprintf("00-%s-%s-01", sc.traceID, sc.spanID)
+}
+
+// Extract reads a traceparent from the carrier
+func (r *Recorder) Extract(ctx context.Context, carrier map[string]string) context.Context {
+	parts := strings.Split(carrier[TraceParentKey], "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, spanContext{traceID: parts[1], spanID: parts[2]})
+}
+
+// Spans returns the finished spans in the order they ended
+func (r *Recorder) Spans() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spans := make([]SpanData, len(r.spans))
+	copy(spans, r.spans)
+	return spans
+}
+
+// Reset drops all recorded spans
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+// recordedSpan is an in-progress span of a Recorder
+type recordedSpan struct {
+	recorder *Recorder
+	data     SpanData
+	ended    bool
+	mu       sync.Mutex
+}
+
+// SetAttribute sets an attribute on the span
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes[key] = value
+}
+
+// RecordError attaches an error to the span
+func (s *recordedSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Err = err
+}
+
+// End finishes the span; later calls are ignored
+func (s *recordedSpan) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	s.recorder.mu.Lock()
+	s.recorder.spans = append(s.recorder.spans, data)
+	s.recorder.mu.Unlock()
+}
+
+// randomHex returns n random bytes hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"testing"
+
+	websocket "github.com/OkanUysal/go-websocket"
+)
+
+func TestMessageAndFanOutShareTrace(t *testing.T) {
+	recorder := NewRecorder()
+	hub := websocket.NewHub(&websocket.Config{Tracer: recorder})
+
+	roomID := hub.CreateRoom(&websocket.RoomConfig{Name: "Traced", Lifecycle: websocket.Persistent})
+	hub.SetOnMessage(func(client *websocket.Client, msg websocket.Message) {
+		hub.BroadcastToRoom(roomID, websocket.Message{Type: "chat.out"}.ContinueTrace(msg))
+	})
+
+	// Trace started by the client
+	clientTrace := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	hub.HandleMessage(websocket.NewClient(hub, nil, "alice"), websocket.Message{
+		Type:  "chat",
+		Trace: map[string]string{TraceParentKey: clientTrace},
+	})
+
+	spans := recorder.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected message and broadcast spans, got %d", len(spans))
+	}
+
+	broadcast, message := spans[0], spans[1]
+	if message.Name != "websocket.message" || broadcast.Name != "websocket.broadcast" {
+		t.Fatalf("Unexpected span names %q, %q", message.Name, broadcast.Name)
+	}
+	if message.TraceID != "0af7651916cd43dd8448eb211c80319c" || message.ParentID != "b7ad6b7169203331" {
+		t.Errorf("Expected message span to continue the client trace, got %+v", message)
+	}
+	if broadcast.TraceID != message.TraceID || broadcast.ParentID != message.SpanID {
+		t.Errorf("Expected broadcast span to be a child of the message span, got %+v", broadcast)
+	}
+	if broadcast.Attributes["room_id"] != roomID || broadcast.Attributes["recipients"] != "0" {
+		t.Errorf("Unexpected broadcast attributes %v", broadcast.Attributes)
+	}
+}
+
+func TestRootSpan(t *testing.T) {
+	recorder := NewRecorder()
+	hub := websocket.NewHub(&websocket.Config{Tracer: recorder})
+
+	hub.HandleMessage(websocket.NewClient(hub, nil, "bob"), websocket.Message{Type: "ping"})
+
+	spans := recorder.Spans()
+	if len(spans) != 1 || spans[0].ParentID != "" || len(spans[0].TraceID) != 32 {
+		t.Errorf("Expected a single root span, got %+v", spans)
+	}
+
+	recorder.Reset()
+	if len(recorder.Spans()) != 0 {
+		t.Error("Expected Reset to drop spans")
+	}
+}
@@ -0,0 +1,48 @@
+package websocket
+
+import (
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// Transport upgrades HTTP requests to connections. Set Config.Transport to
+// run the hub on another WebSocket library, wrapping its connection as a
+// Conn; gorilla/websocket is used by default.
+type Transport interface {
+	Upgrade(w http.ResponseWriter, r *http.Request) (Conn, error)
+}
+
+// subprotocoler is implemented by connections that negotiate subprotocols
+type subprotocoler interface {
+	Subprotocol() string
+}
+
+// GorillaTransport upgrades with gorilla/websocket
+type GorillaTransport struct {
+	Upgrader websocket.Upgrader
+}
+
+// NewGorillaTransport creates the default transport with the buffer sizes
+// from config. All origins are allowed; set Upgrader.CheckOrigin in
+// production.
+func NewGorillaTransport(config *Config) *GorillaTransport {
+	return &GorillaTransport{
+		Upgrader: websocket.Upgrader{
+			ReadBufferSize:  config.ReadBufferSize,
+			WriteBufferSize: config.WriteBufferSize,
+			CheckOrigin: func(r *http.Request) bool {
+				return true
+			},
+		},
+	}
+}
+
+// Upgrade upgrades the request to a gorilla connection
+func (t *GorillaTransport) Upgrade(w http.ResponseWriter, r *http.Request) (Conn, error) {
+	conn, err := t.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
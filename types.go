@@ -1,14 +1,17 @@
 package websocket
 
 import (
+	"log/slog"
 	"sync"
 	"time"
 )
 
 // Message represents a WebSocket message
 type Message struct {
-	Type string                 `json:"type"`
-	Data map[string]interface{} `json:"data"`
+	ID    int64                  `json:"id,omitempty"` // set when recorded in room history
+	Type  string                 `json:"type"`
+	Data  map[string]interface{} `json:"data"`
+	Trace map[string]string      `json:"trace,omitempty"` // trace context, e.g. W3C traceparent
 }
 
 // Room represents a chat room or channel
@@ -22,9 +25,38 @@ type Room struct {
 	CreatedAt  time.Time
 	CreatedBy  string
 	Metadata   map[string]interface{}
+
+	Lifecycle   RoomLifecycle
+	IdleTimeout time.Duration
+	History     *HistoryConfig
+
+	members    map[string]*RoomMember
+	signals    map[string]*roomSignal
+	signalSent map[string]time.Time
+	idleTimer  Timer
+	closed     bool
 	mu         sync.RWMutex
 }
 
+// RoomLifecycle controls what happens to a room once it has no clients
+type RoomLifecycle int
+
+const (
+	// CloseWhenEmpty closes the room as soon as the last client leaves (default)
+	CloseWhenEmpty RoomLifecycle = iota
+	// Persistent keeps the room open until CloseRoom is called explicitly
+	Persistent
+	// CloseAfterIdle closes the room once it has been empty for IdleTimeout
+	CloseAfterIdle
+)
+
+// Reasons passed to the onRoomClosed hook and the room_closed message
+const (
+	CloseReasonManual = "closed"
+	CloseReasonEmpty  = "empty"
+	CloseReasonIdle   = "idle_timeout"
+)
+
 // RoomConfig contains configuration for creating a room
 type RoomConfig struct {
 	Name       string
@@ -32,6 +64,19 @@ type RoomConfig struct {
 	IsPrivate  bool                   // false = public
 	Password   string                 // for private rooms
 	Metadata   map[string]interface{} // custom data
+
+	Lifecycle   RoomLifecycle // default CloseWhenEmpty
+	IdleTimeout time.Duration // used with CloseAfterIdle, 0 = 5 minutes
+
+	History *HistoryConfig // nil = no history
+}
+
+// HistoryConfig enables message history for a room
+type HistoryConfig struct {
+	MaxMessages  int           // keep the last N messages, 0 = unlimited
+	MaxAge       time.Duration // keep messages newer than this, 0 = unlimited
+	ReplayOnJoin int           // messages sent to a client on JoinRoom, 0 = none
+	KeepOnClose  bool          // keep history in the store after CloseRoom
 }
 
 // RoomInfo represents public room information
@@ -57,8 +102,66 @@ type Config struct {
 
 	// Optional cache for distributed mode (from go-cache)
 	Cache interface{} // *cache.Cache - interface to avoid hard dependency
+
+	// Storage for room history (nil = in-memory)
+	MessageStore MessageStore
+
+	// Offline message queue (nil = disabled)
+	Offline *OfflineConfig
+
+	// Presence tracking (nil = disabled)
+	Presence *PresenceConfig
+
+	// Ephemeral room signals such as typing indicators
+	SignalTTL      time.Duration // auto-clear after this, 0 = 5s
+	SignalThrottle time.Duration // min interval between broadcasts per user, 0 = 1s
+
+	// Optional instrumentation (see the metrics package)
+	Metrics Metrics
+
+	// Structured logger (nil = warnings and errors to stderr)
+	Logger *slog.Logger
+
+	// Optional tracing of message handling and broadcasts
+	Tracer Tracer
+
+	// Round-trip time above which the onHighLatency hook fires (0 = disabled)
+	LatencyThreshold time.Duration
+
+	// Take Client.RemoteIP from X-Forwarded-For / X-Real-IP. Enable only
+	// behind a single proxy that sets them; the last X-Forwarded-For entry
+	// is used.
+	TrustProxyHeaders bool
+
+	// Source of time for pings, timeouts and debouncing (nil = real time)
+	Clock Clock
+
+	// WebSocket implementation used by HandleConnection (nil = gorilla/websocket)
+	Transport Transport
+
+	// Serve connections from an epoll event loop (nil = two goroutines per connection)
+	Netpoll *NetpollConfig
+
+	// Number of lock shards for clients and rooms (0 = 32)
+	Shards int
+}
+
+// OfflineConfig enables queueing messages for disconnected users
+type OfflineConfig struct {
+	Store       OfflineStore  // nil = MessageStore if it implements OfflineStore, else in-memory
+	MaxMessages int           // per user, oldest dropped first (default 100)
+	TTL         time.Duration // drop undelivered messages after this, 0 = keep
 }
 
+// DeliveryStatus reports what happened to a message sent to a user
+type DeliveryStatus string
+
+const (
+	DeliveryDelivered DeliveryStatus = "delivered" // sent to a connected client
+	DeliveryQueued    DeliveryStatus = "queued"    // stored until the user connects
+	DeliveryFailed    DeliveryStatus = "failed"    // user offline and queueing disabled
+)
+
 // DefaultConfig returns default configuration
 func DefaultConfig() *Config {
 	return &Config{
@@ -1,6 +1,20 @@
 package websocket
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -34,6 +48,9 @@ func TestNewHub(t *testing.T) {
 		if hub.config.ReadBufferSize != 2048 {
 			t.Errorf("Expected read buffer 2048, got %d", hub.config.ReadBufferSize)
 		}
+		if hub.config.PongWait == 0 || config.PongWait != 0 {
+			t.Error("Expected defaults to be filled into the hub's copy only")
+		}
 	})
 }
 
@@ -143,6 +160,32 @@ func TestRoomManagement(t *testing.T) {
 	})
 }
 
+func TestJoinRaces(t *testing.T) {
+	hub := NewHub(nil)
+	client := newTestClient(hub, "alice")
+
+	for i := 0; i < 100; i++ {
+		roomID := hub.CreateRoom(&RoomConfig{Name: "Race"})
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			hub.JoinRoom("alice", roomID)
+		}()
+		go func() {
+			defer wg.Done()
+			hub.CloseRoom(roomID)
+		}()
+		wg.Wait()
+		drain(client)
+
+		if client.InRoom(roomID) {
+			t.Fatalf("Expected closed room %s not to be in the client's rooms", roomID)
+		}
+	}
+}
+
 func TestRoomInfo(t *testing.T) {
 	hub := NewHub(nil)
 
@@ -263,3 +306,1217 @@ func TestGetOnlineUsers(t *testing.T) {
 		t.Errorf("Expected 0 users, got %d", len(users))
 	}
 }
+
+// newTestClient registers a client without a network connection
+func newTestClient(hub *Hub, userID string) *Client {
+	client := NewClient(hub, nil, userID)
+	hub.registerClient(client)
+	return client
+}
+
+func TestRoomLifecycle(t *testing.T) {
+	hub := NewHub(nil)
+
+	closed := make(chan string, 10)
+	hub.SetOnRoomClosed(func(room *Room, reason string) {
+		closed <- reason
+	})
+
+	newTestClient(hub, "user1")
+
+	t.Run("close when empty", func(t *testing.T) {
+		roomID := hub.CreateRoom(&RoomConfig{Name: "Default"})
+		hub.JoinRoom("user1", roomID)
+		hub.LeaveRoom("user1", roomID)
+
+		if hub.RoomExists(roomID) {
+			t.Error("Expected room to be closed when empty")
+		}
+		if reason := <-closed; reason != CloseReasonEmpty {
+			t.Errorf("Expected reason %q, got %q", CloseReasonEmpty, reason)
+		}
+	})
+
+	t.Run("persistent", func(t *testing.T) {
+		roomID := hub.CreateRoom(&RoomConfig{Name: "Lobby", Lifecycle: Persistent})
+		hub.JoinRoom("user1", roomID)
+		hub.LeaveRoom("user1", roomID)
+
+		if !hub.RoomExists(roomID) {
+			t.Error("Expected persistent room to survive becoming empty")
+		}
+	})
+
+	t.Run("close after idle", func(t *testing.T) {
+		roomID := hub.CreateRoom(&RoomConfig{
+			Name:        "Idle",
+			Lifecycle:   CloseAfterIdle,
+			IdleTimeout: 20 * time.Millisecond,
+		})
+		hub.JoinRoom("user1", roomID)
+		time.Sleep(40 * time.Millisecond)
+		if !hub.RoomExists(roomID) {
+			t.Fatal("Expected occupied room to stay open")
+		}
+
+		hub.LeaveRoom("user1", roomID)
+		select {
+		case reason := <-closed:
+			if reason != CloseReasonIdle {
+				t.Errorf("Expected reason %q, got %q", CloseReasonIdle, reason)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected idle room to be closed")
+		}
+		if hub.RoomExists(roomID) {
+			t.Error("Expected idle room to be removed")
+		}
+	})
+
+	t.Run("close after idle without timeout", func(t *testing.T) {
+		roomID := hub.CreateRoom(&RoomConfig{Name: "Idle", Lifecycle: CloseAfterIdle})
+		time.Sleep(20 * time.Millisecond)
+		if room := hub.GetRoom(roomID); room == nil || room.IdleTimeout != defaultIdleTimeout {
+			t.Errorf("Expected the default idle timeout to apply, got %v", room)
+		}
+	})
+}
+
+func TestRoomHistory(t *testing.T) {
+	hub := NewHub(nil)
+
+	roomID := hub.CreateRoom(&RoomConfig{
+		Name:      "Chat",
+		Lifecycle: Persistent,
+		History: &HistoryConfig{
+			MaxMessages:  5,
+			ReplayOnJoin: 2,
+		},
+	})
+
+	for i := 1; i <= 7; i++ {
+		hub.BroadcastToRoom(roomID, Message{
+			Type: "chat",
+			Data: map[string]interface{}{"n": i},
+		})
+	}
+
+	t.Run("retention", func(t *testing.T) {
+		entries, err := hub.GetRoomHistory(roomID, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 5 {
+			t.Fatalf("Expected 5 entries, got %d", len(entries))
+		}
+		if entries[0].ID != 3 || entries[4].ID != 7 {
+			t.Errorf("Expected IDs 3..7, got %d..%d", entries[0].ID, entries[4].ID)
+		}
+	})
+
+	t.Run("paging", func(t *testing.T) {
+		entries, _ := hub.GetRoomHistory(roomID, 6, 2)
+		if len(entries) != 2 || entries[0].ID != 4 || entries[1].ID != 5 {
+			t.Errorf("Expected IDs 4,5 got %+v", entries)
+		}
+	})
+
+	t.Run("replay on join", func(t *testing.T) {
+		client := newTestClient(hub, "reader")
+		if err := hub.JoinRoom("reader", roomID); err != nil {
+			t.Fatal(err)
+		}
+
+		<-client.Send // room.members
+		msg := <-client.Send
+		if msg.Type != "room_history" {
+			t.Fatalf("Expected room_history, got %s", msg.Type)
+		}
+		entries := msg.Data["messages"].([]HistoryEntry)
+		if len(entries) != 2 || entries[1].Message.ID != 7 {
+			t.Errorf("Expected last 2 messages, got %+v", entries)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		plainID := hub.CreateRoom(&RoomConfig{Name: "Plain"})
+		if _, err := hub.GetRoomHistory(plainID, 0, 10); err == nil {
+			t.Error("Expected error for room without history")
+		}
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	config := &FileStoreConfig{Dir: dir, SegmentSize: 256}
+
+	store, err := NewFileStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		store.Append("lobby", Message{Type: "chat", Data: map[string]interface{}{"n": i}})
+	}
+	store.Trim("lobby", 4, 0)
+	store.Enqueue("user1", "", Message{Type: "dm"})
+	store.Enqueue("user1", "", Message{Type: "dm"})
+	store.Ack("user1", 1)
+	store.Close()
+
+	t.Run("survives restart", func(t *testing.T) {
+		store, err := NewFileStore(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		entries, _ := store.History("lobby", 0, 0)
+		if len(entries) != 4 || entries[0].ID != 7 || entries[3].ID != 10 {
+			t.Fatalf("Expected IDs 7..10 after restart, got %+v", entries)
+		}
+		pending, _ := store.Pending("user1")
+		if len(pending) != 1 || pending[0].ID != 2 {
+			t.Errorf("Expected one pending message with ID 2, got %+v", pending)
+		}
+	})
+
+	t.Run("compaction keeps IDs", func(t *testing.T) {
+		store, err := NewFileStore(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Trim("lobby", 1, 0)
+		if err := store.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		store.Close()
+
+		segments, _ := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+		if len(segments) != 2 {
+			t.Errorf("Expected compacted and active segment, got %v", segments)
+		}
+
+		store, err = NewFileStore(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		entry, _ := store.Append("lobby", Message{Type: "chat"})
+		if entry.ID != 11 {
+			t.Errorf("Expected next ID 11, got %d", entry.ID)
+		}
+		entries, _ := store.History("lobby", 0, 0)
+		if len(entries) != 2 {
+			t.Errorf("Expected 2 entries, got %d", len(entries))
+		}
+	})
+
+	t.Run("size retention", func(t *testing.T) {
+		store, err := NewFileStore(&FileStoreConfig{Dir: t.TempDir(), MaxSize: 1024})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		for i := 0; i < 50; i++ {
+			store.Append("big", Message{Type: "chat", Data: map[string]interface{}{"text": "hello world"}})
+		}
+		store.Compact()
+
+		entries, _ := store.History("big", 0, 0)
+		if len(entries) == 0 || len(entries) >= 50 {
+			t.Errorf("Expected oldest entries to be dropped, got %d", len(entries))
+		}
+		if entries[len(entries)-1].ID != 50 {
+			t.Errorf("Expected newest entry to be kept")
+		}
+	})
+
+	t.Run("retention survives crash during compaction", func(t *testing.T) {
+		dir := t.TempDir()
+		config := &FileStoreConfig{Dir: dir, MaxSize: 1024}
+		store, err := NewFileStore(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.SegmentSize != 0 {
+			t.Error("Expected defaults not to be written into the caller's config")
+		}
+		for i := 0; i < 50; i++ {
+			store.Append("big", Message{Type: "chat", Data: map[string]interface{}{"text": "hello world"}})
+		}
+
+		// Keep the segments a crash before their removal would leave behind
+		old, _ := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+		saved := make(map[string][]byte)
+		for _, name := range old {
+			saved[name], _ = os.ReadFile(name)
+		}
+
+		if err := store.Compact(); err != nil {
+			t.Fatal(err)
+		}
+		kept, _ := store.History("big", 0, 0)
+		store.Close()
+		if err := store.Compact(); err == nil {
+			t.Error("Expected Compact to fail after Close")
+		}
+
+		for name, data := range saved {
+			os.WriteFile(name, data, 0o644)
+		}
+		store, err = NewFileStore(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		entries, _ := store.History("big", 0, 0)
+		if len(entries) != len(kept) || entries[0].ID != kept[0].ID {
+			t.Errorf("Expected %d entries from ID %d after replaying old segments, got %d", len(kept), kept[0].ID, len(entries))
+		}
+		if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) != 0 {
+			t.Errorf("Expected no temporary files, got %v", tmp)
+		}
+	})
+}
+
+func TestOfflineMessages(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		hub := NewHub(nil)
+		status, err := hub.SendToUserWithStatus("nobody", Message{Type: "dm"})
+		if err == nil || status != DeliveryFailed {
+			t.Errorf("Expected failed delivery, got %s (%v)", status, err)
+		}
+	})
+
+	t.Run("queued and flushed in order", func(t *testing.T) {
+		hub := NewHub(&Config{Offline: &OfflineConfig{MaxMessages: 2}})
+		sender := newTestClient(hub, "alice")
+
+		for i := 1; i <= 3; i++ {
+			status, err := hub.SendFromUser("alice", "bob", Message{
+				Type: "dm",
+				Data: map[string]interface{}{"n": i},
+			})
+			if err != nil || status != DeliveryQueued {
+				t.Fatalf("Expected queued, got %s (%v)", status, err)
+			}
+			if msg := <-sender.Send; msg.Type != "message_queued" {
+				t.Errorf("Expected message_queued, got %s", msg.Type)
+			}
+		}
+
+		bob := newTestClient(hub, "bob")
+		for _, want := range []int{2, 3} {
+			msg := <-bob.Send
+			if msg.Data["n"] != want {
+				t.Errorf("Expected message %d, got %v", want, msg.Data["n"])
+			}
+		}
+		if msg := <-sender.Send; msg.Type != "message_delivered" {
+			t.Errorf("Expected message_delivered, got %s", msg.Type)
+		}
+
+		pending, _ := hub.GetPendingMessages("bob")
+		if len(pending) != 0 {
+			t.Errorf("Expected queue to be empty, got %d", len(pending))
+		}
+		if status, _ := hub.SendToUserWithStatus("bob", Message{Type: "dm"}); status != DeliveryDelivered {
+			t.Errorf("Expected delivered, got %s", status)
+		}
+	})
+
+	t.Run("kick notice not queued", func(t *testing.T) {
+		hub := NewHub(&Config{Offline: &OfflineConfig{}})
+		roomID := hub.CreateRoom(&RoomConfig{Name: "Arena"})
+
+		start := time.Now()
+		hub.KickFromRoom("bob", roomID, "afk")
+		if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+			t.Errorf("Expected no wait for an offline user, took %v", elapsed)
+		}
+		if pending, _ := hub.GetPendingMessages("bob"); len(pending) != 0 {
+			t.Errorf("Expected no queued kick notice, got %+v", pending)
+		}
+	})
+}
+
+func TestPresence(t *testing.T) {
+	hub := NewHub(&Config{Presence: &PresenceConfig{}})
+	alice := newTestClient(hub, "alice")
+	bob := newTestClient(hub, "bob")
+
+	hub.HandleMessage(alice, Message{
+		Type: "presence.subscribe",
+		Data: map[string]interface{}{"user_ids": []interface{}{"bob"}},
+	})
+	if msg := <-alice.Send; msg.Type != "presence.changed" || msg.Data["status"] != "online" {
+		t.Fatalf("Expected initial online presence, got %+v", msg)
+	}
+
+	hub.HandleMessage(bob, Message{
+		Type: "presence.set",
+		Data: map[string]interface{}{"status": "busy", "text": "In a match"},
+	})
+	msg := <-alice.Send
+	if msg.Data["status"] != "busy" || msg.Data["text"] != "In a match" {
+		t.Errorf("Expected busy presence, got %+v", msg)
+	}
+
+	hub.unregisterClient(bob)
+	msg = <-alice.Send
+	if msg.Data["status"] != "offline" || msg.Data["last_seen"] == nil {
+		t.Errorf("Expected offline presence with last seen, got %+v", msg)
+	}
+	if p := hub.GetPresence("bob"); p.LastSeen.IsZero() {
+		t.Error("Expected last seen to be recorded")
+	}
+}
+
+func TestPresenceDebounce(t *testing.T) {
+	hub := NewHub(&Config{Presence: &PresenceConfig{Debounce: 50 * time.Millisecond}})
+	alice := newTestClient(hub, "alice")
+	bob := newTestClient(hub, "bob")
+	time.Sleep(100 * time.Millisecond)
+
+	hub.SubscribePresence("alice", "bob")
+	<-alice.Send
+
+	hub.SubscribePresence("bob", "alice")
+	<-bob.Send
+	hub.SetPresence("bob", StatusOnline, "In a match")
+	time.Sleep(100 * time.Millisecond)
+	<-alice.Send
+
+	// Flapping connection: offline and back online within the window
+	hub.unregisterClient(bob)
+	bob = newTestClient(hub, "bob")
+	time.Sleep(100 * time.Millisecond)
+
+	select {
+	case msg := <-alice.Send:
+		t.Errorf("Expected no presence change, got %+v", msg)
+	default:
+	}
+	if p := hub.GetPresence("bob"); p.Text != "In a match" {
+		t.Errorf("Expected custom text to survive a reconnect, got %q", p.Text)
+	}
+
+	// Subscriptions survive the flap and are dropped once offline is announced
+	hub.SetPresence("alice", StatusAway, "")
+	time.Sleep(100 * time.Millisecond)
+	if msg := <-bob.Send; msg.Data["status"] != "away" {
+		t.Errorf("Expected bob to stay subscribed, got %+v", msg)
+	}
+	hub.unregisterClient(bob)
+	time.Sleep(100 * time.Millisecond)
+	<-alice.Send
+	if len(hub.presence.subscriptions["bob"]) != 0 {
+		t.Error("Expected bob's subscriptions to be dropped after going offline")
+	}
+}
+
+func TestRoomMembers(t *testing.T) {
+	hub := NewHub(nil)
+	alice := newTestClient(hub, "alice")
+	bob := newTestClient(hub, "bob")
+	roomID := hub.CreateRoom(&RoomConfig{Name: "Match"})
+
+	hub.JoinRoomWithMember("alice", roomID, &RoomMember{DisplayName: "Alice", Team: "red"})
+	<-alice.Send // room.members
+	<-alice.Send // user_joined
+
+	hub.JoinRoomWithMember("bob", roomID, &RoomMember{DisplayName: "Bob", Team: "blue"})
+	msg := <-bob.Send
+	if msg.Type != "room.members" {
+		t.Fatalf("Expected room.members snapshot, got %s", msg.Type)
+	}
+	members := msg.Data["members"].([]RoomMember)
+	if len(members) != 2 || members[0].DisplayName != "Alice" {
+		t.Errorf("Expected Alice and Bob in join order, got %+v", members)
+	}
+	<-alice.Send // user_joined
+	<-bob.Send   // user_joined
+
+	hub.HandleMessage(alice, Message{
+		Type: "room.update_member",
+		Data: map[string]interface{}{"room_id": roomID, "ready": true, "team": "red"},
+	})
+	msg = <-bob.Send
+	changes := msg.Data["changes"].(map[string]interface{})
+	if msg.Type != "room.member_updated" || len(changes) != 1 || changes["ready"] != true {
+		t.Errorf("Expected only ready to change, got %+v", msg)
+	}
+
+	if member, ok := hub.GetRoomMember(roomID, "alice"); !ok || !member.Ready {
+		t.Error("Expected alice to be ready")
+	}
+}
+
+// drain discards queued messages for a test client
+func drain(client *Client) {
+	for {
+		select {
+		case <-client.Send:
+		default:
+			return
+		}
+	}
+}
+
+func TestRoomSignals(t *testing.T) {
+	hub := NewHub(&Config{
+		SignalTTL:      50 * time.Millisecond,
+		SignalThrottle: time.Second,
+	})
+	alice := newTestClient(hub, "alice")
+	bob := newTestClient(hub, "bob")
+	roomID := hub.CreateRoom(&RoomConfig{
+		Name:    "Chat",
+		History: &HistoryConfig{},
+	})
+	hub.JoinRoom("alice", roomID)
+	hub.JoinRoom("bob", roomID)
+	drain(alice)
+	drain(bob)
+
+	t.Run("throttled and expires", func(t *testing.T) {
+		if err := hub.SendRoomSignal(roomID, "alice", "typing", nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := hub.SendRoomSignal(roomID, "alice", "typing", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		msg := <-bob.Send
+		if msg.Type != "room.signal" || msg.Data["active"] != true {
+			t.Fatalf("Expected active signal, got %+v", msg)
+		}
+		select {
+		case msg = <-bob.Send:
+			if msg.Data["active"] != false {
+				t.Errorf("Expected only one broadcast before expiry, got %+v", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected signal to expire")
+		}
+
+		entries, _ := hub.GetRoomHistory(roomID, 0, 0)
+		if len(entries) != 0 {
+			t.Errorf("Expected signals to stay out of history, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("cleared on disconnect", func(t *testing.T) {
+		drain(alice)
+		drain(bob)
+		hub.SendRoomSignal(roomID, "bob", "typing", nil)
+		<-alice.Send
+
+		hub.unregisterClient(bob)
+		msg := <-alice.Send
+		if msg.Type != "room.signal" || msg.Data["active"] != false {
+			t.Errorf("Expected signal to be cleared, got %+v", msg)
+		}
+		if msg := <-alice.Send; msg.Type != "user_left" {
+			t.Errorf("Expected user_left, got %s", msg.Type)
+		}
+	})
+}
+
+func TestReadReceipts(t *testing.T) {
+	hub := NewHub(nil)
+	alice := newTestClient(hub, "alice")
+	bob := newTestClient(hub, "bob")
+	roomID := hub.CreateRoom(&RoomConfig{Name: "Chat", History: &HistoryConfig{}})
+	hub.JoinRoom("alice", roomID)
+	hub.JoinRoom("bob", roomID)
+
+	for i := 0; i < 5; i++ {
+		hub.BroadcastToRoom(roomID, Message{Type: "chat"})
+	}
+	drain(alice)
+	drain(bob)
+
+	if unread, _ := hub.GetUnreadCount(roomID, "bob"); unread != 5 {
+		t.Errorf("Expected 5 unread, got %d", unread)
+	}
+
+	hub.HandleMessage(bob, Message{
+		Type: "room.read",
+		Data: map[string]interface{}{"room_id": roomID, "message_id": float64(3)},
+	})
+
+	msg := <-alice.Send
+	if msg.Type != "room.receipt" || msg.Data["user_id"] != "bob" || msg.Data["message_id"] != int64(3) {
+		t.Errorf("Expected receipt for bob at 3, got %+v", msg)
+	}
+	select {
+	case msg := <-bob.Send:
+		t.Errorf("Expected reader not to get its own receipt, got %+v", msg)
+	default:
+	}
+
+	if unread, _ := hub.GetUnreadCount(roomID, "bob"); unread != 2 {
+		t.Errorf("Expected 2 unread, got %d", unread)
+	}
+
+	// Cursors never move backwards
+	hub.MarkRead(roomID, "bob", 1)
+	if cursor := hub.GetReadCursor(roomID, "bob"); cursor != 3 {
+		t.Errorf("Expected cursor 3, got %d", cursor)
+	}
+
+	// Non-members cannot mark messages read
+	newTestClient(hub, "carol")
+	if err := hub.MarkRead(roomID, "carol", 5); err == nil {
+		t.Error("Expected MarkRead to fail for a non-member")
+	}
+	if cursor := hub.GetReadCursor(roomID, "carol"); cursor != 0 {
+		t.Errorf("Expected no cursor for a non-member, got %d", cursor)
+	}
+}
+
+func TestStructuredLogging(t *testing.T) {
+	var buf bytes.Buffer
+	hub := NewHub(&Config{
+		Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	client := newTestClient(hub, "alice")
+	roomID := hub.CreateRoom(&RoomConfig{Name: "Logged"})
+	hub.JoinRoom("alice", roomID)
+	hub.HandleMessage(client, Message{Type: "chat"})
+
+	out := buf.String()
+	for _, want := range []string{
+		`"msg":"client connected"`,
+		`"user_id":"alice"`,
+		`"client_id":"` + client.ID + `"`,
+		`"room_id":"` + roomID + `"`,
+		`"level":"DEBUG","msg":"message received"`,
+		`"type":"chat"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected log output to contain %s\n%s", want, out)
+		}
+	}
+}
+
+func TestClientLatency(t *testing.T) {
+	hub := NewHub(&Config{LatencyThreshold: 100 * time.Millisecond})
+
+	var alerts []time.Duration
+	hub.SetOnHighLatency(func(client *Client, rtt time.Duration) {
+		alerts = append(alerts, rtt)
+	})
+
+	pongAfter := func(client *Client, rtt time.Duration) {
+		sent := time.Now().Add(-rtt).UnixNano()
+		client.recordPong(strconv.FormatInt(sent, 10))
+	}
+
+	fast := newTestClient(hub, "fast")
+	slow := newTestClient(hub, "slow")
+	newTestClient(hub, "unmeasured")
+
+	pongAfter(fast, 20*time.Millisecond)
+	pongAfter(slow, 200*time.Millisecond)
+	pongAfter(slow, 300*time.Millisecond)
+
+	if rtt := fast.Latency(); rtt < 20*time.Millisecond || rtt > 50*time.Millisecond {
+		t.Errorf("Expected ~20ms latency, got %v", rtt)
+	}
+	if len(alerts) != 1 {
+		t.Errorf("Expected one alert while above threshold, got %d", len(alerts))
+	}
+
+	// Dropping below and rising again alerts again
+	pongAfter(slow, 10*time.Millisecond)
+	pongAfter(slow, 250*time.Millisecond)
+	if len(alerts) != 2 {
+		t.Errorf("Expected a second alert, got %d", len(alerts))
+	}
+
+	stats := hub.GetLatencyStats()
+	if stats.Clients != 2 {
+		t.Errorf("Expected 2 measured clients, got %d", stats.Clients)
+	}
+	if stats.Max < 250*time.Millisecond || stats.P50 > 50*time.Millisecond {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// Malformed payloads are ignored
+	fast.recordPong("not-a-timestamp")
+	if fast.Latency() == 0 {
+		t.Error("Expected latency to be kept")
+	}
+}
+
+func TestRoomHooks(t *testing.T) {
+	hub := NewHub(nil)
+
+	var events []string
+	hub.SetOnRoomCreated(func(room *Room) {
+		events = append(events, "created:"+room.Name)
+	})
+	hub.SetOnBeforeJoin(func(client *Client, room *Room) error {
+		if client.UserID == "banned" {
+			return errors.New("banned from room")
+		}
+		return nil
+	})
+	hub.SetOnJoined(func(client *Client, room *Room) {
+		events = append(events, "joined:"+client.UserID)
+	})
+	hub.SetOnLeft(func(userID string, room *Room) {
+		events = append(events, "left:"+userID)
+	})
+	hub.SetOnKicked(func(userID string, room *Room, reason string) {
+		events = append(events, "kicked:"+userID+":"+reason)
+	})
+	hub.SetOnRoomClosed(func(room *Room, reason string) {
+		events = append(events, "closed:"+reason)
+	})
+
+	newTestClient(hub, "alice")
+	banned := newTestClient(hub, "banned")
+
+	roomID := hub.CreateRoom(&RoomConfig{Name: "Arena"})
+	if err := hub.JoinRoom("banned", roomID); err == nil || err.Error() != "banned from room" {
+		t.Errorf("Expected join to be vetoed, got %v", err)
+	}
+	if msg := <-banned.Send; msg.Type != "join_denied" || msg.Data["reason"] != "banned from room" {
+		t.Errorf("Expected join_denied with reason, got %+v", msg)
+	}
+	if hub.GetRoomClientCount(roomID) != 0 {
+		t.Error("Expected vetoed user not to be in room")
+	}
+
+	if err := hub.KickFromRoom("banned", roomID, "afk"); err != ErrNotInRoom {
+		t.Errorf("Expected kicking a non-member to fail, got %v", err)
+	}
+
+	hub.JoinRoom("alice", roomID)
+	hub.KickFromRoom("alice", roomID, "afk")
+
+	want := []string{"created:Arena", "joined:alice", "kicked:alice:afk", "left:alice", "closed:empty"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected events %v, got %v", want, events)
+	}
+}
+
+func TestHookSubscribers(t *testing.T) {
+	var logs bytes.Buffer
+	hub := NewHub(&Config{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+
+	var events []string
+	hub.OnConnect(func(ctx context.Context, client *Client) {
+		panic("bad listener")
+	})
+	unsubscribe := hub.OnConnect(func(ctx context.Context, client *Client) {
+		events = append(events, "first:"+client.UserID)
+	})
+	hub.OnConnect(func(ctx context.Context, client *Client) {
+		events = append(events, "second:"+client.UserID)
+	})
+	hub.SetOnConnect(func(client *Client) {
+		events = append(events, "old:"+client.UserID)
+	})
+	hub.SetOnConnect(func(client *Client) {
+		events = append(events, "set:"+client.UserID)
+	})
+
+	newTestClient(hub, "alice")
+	unsubscribe()
+	unsubscribe()
+	newTestClient(hub, "bob")
+
+	want := []string{"first:alice", "second:alice", "set:alice", "second:bob", "set:bob"}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected events %v, got %v", want, events)
+	}
+	if !strings.Contains(logs.String(), "hook panicked") || !strings.Contains(logs.String(), "event=connect") {
+		t.Errorf("Expected panic to be logged, got %q", logs.String())
+	}
+
+	// A panicking veto listener rejects the join
+	hub.OnBeforeJoin(func(client *Client, room *Room) error {
+		panic("bad veto")
+	})
+	roomID := hub.CreateRoom(&RoomConfig{Name: "Arena"})
+	if err := hub.JoinRoom("alice", roomID); err == nil {
+		t.Error("Expected join to be rejected by panicking hook")
+	}
+}
+
+func TestHookReplaceAtomic(t *testing.T) {
+	var l hookList[func() int]
+	l.replace(func() int { return 0 }, false)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 1000; i++ {
+			i := i
+			l.replace(func() int { return i }, false)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if fns := l.snapshot(); len(fns) != 1 {
+			t.Fatalf("Expected exactly one listener during replace, got %d", len(fns))
+		}
+	}
+}
+
+func TestDisconnectFromHook(t *testing.T) {
+	hub := NewHub(nil)
+	go hub.Run()
+	defer hub.Close()
+
+	hub.OnConnect(func(ctx context.Context, client *Client) {
+		hub.DisconnectUser(client.UserID)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		hub.register(NewClient(hub, nil, "alice"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected DisconnectUser from an OnConnect hook not to deadlock")
+	}
+
+	for i := 0; i < 100 && hub.GetClient("alice") != nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hub.GetClient("alice") != nil {
+		t.Error("Expected alice to be disconnected")
+	}
+}
+
+func TestRegisterAfterClose(t *testing.T) {
+	hub := NewHub(nil)
+	hub.Close()
+
+	client := newTestClient(hub, "alice")
+	if hub.GetClient("alice") != nil {
+		t.Error("Expected a client registered after Close to be removed")
+	}
+	if _, ok := <-client.Send; ok {
+		t.Error("Expected the client's Send channel to be closed")
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	hub := NewHub(nil)
+	go hub.Run()
+
+	type requestKey struct{}
+	received := make(chan context.Context, 1)
+	hub.OnMessage(func(ctx context.Context, client *Client, msg Message) {
+		received <- ctx
+	})
+
+	client := newClient(context.WithValue(context.Background(), requestKey{}, "req-1"), hub, nil, "alice")
+	hub.registerClient(client)
+
+	hub.HandleMessage(client, Message{Type: "chat"})
+	ctx := <-received
+	if ctx.Value(requestKey{}) != "req-1" {
+		t.Error("Expected handler context to carry request values")
+	}
+	if ctx.Err() != nil {
+		t.Error("Expected context to be live while connected")
+	}
+
+	hub.DisconnectUser("alice")
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("Expected context to be cancelled on disconnect")
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	hub := NewHub(nil)
+
+	r := httptest.NewRequest("GET", "/ws?room=lobby&v=2", nil)
+	r.RemoteAddr = "203.0.113.7:51234"
+	r.Header.Set("User-Agent", "game-client/1.4")
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 198.51.100.1")
+	r.Header.Set("Cookie", "session=abc")
+
+	client := newTestClient(hub, "alice")
+	client.handshake = newHandshake(r, nil, hub.config)
+
+	if client.RemoteIP() != "203.0.113.7" {
+		t.Errorf("Expected peer IP without trusted proxy, got %s", client.RemoteIP())
+	}
+	if client.Header("User-Agent") != "game-client/1.4" || client.Query("room") != "lobby" {
+		t.Error("Expected header and query to be captured")
+	}
+	if cookie := client.Cookie("session"); cookie == nil || cookie.Value != "abc" {
+		t.Errorf("Expected session cookie, got %v", cookie)
+	}
+
+	// Copies can't modify the captured request
+	hs := client.Handshake()
+	hs.Header.Set("User-Agent", "changed")
+	hs.Query.Set("room", "changed")
+	if client.Header("User-Agent") != "game-client/1.4" || client.Query("room") != "lobby" {
+		t.Error("Expected handshake to be read-only")
+	}
+
+	hub.config.TrustProxyHeaders = true
+	if ip := newHandshake(r, nil, hub.config).RemoteIP; ip != "198.51.100.1" {
+		t.Errorf("Expected the proxy's entry, not the spoofed one, got %s", ip)
+	}
+	r.Header.Add("X-Forwarded-For", "198.51.100.2")
+	if ip := newHandshake(r, nil, hub.config).RemoteIP; ip != "198.51.100.2" {
+		t.Errorf("Expected the last forwarded header to win, got %s", ip)
+	}
+
+	if newTestClient(hub, "bob").Handshake().Header != nil {
+		t.Error("Expected empty handshake without upgrade request")
+	}
+}
+
+func TestClientConcurrency(t *testing.T) {
+	hub := NewHub(nil)
+
+	const users = 8
+	clients := make([]*Client, users)
+	for i := range clients {
+		clients[i] = newTestClient(hub, "user"+strconv.Itoa(i))
+		go func(client *Client) {
+			for range client.Send {
+			}
+		}(clients[i])
+	}
+
+	roomIDs := make([]string, 4)
+	for i := range roomIDs {
+		roomIDs[i] = hub.CreateRoom(&RoomConfig{Name: "room", Lifecycle: Persistent})
+	}
+
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				roomID := roomIDs[(i+n)%len(roomIDs)]
+				switch n % 5 {
+				case 0, 1:
+					hub.JoinRoom(client.UserID, roomID)
+				case 2:
+					hub.LeaveRoom(client.UserID, roomID)
+				case 3:
+					client.SetMetadata("n", n)
+					client.GetMetadata("n")
+					client.InRoom(roomID)
+					hub.GetUserRooms(client.UserID)
+				case 4:
+					if n%50 == 4 {
+						hub.CloseRoom(roomID)
+						hub.CreateRoomWithID(roomID, &RoomConfig{Name: "room", Lifecycle: Persistent})
+					}
+					client.DeleteMetadata("n")
+					client.RoomList()
+				}
+			}
+		}(i, client)
+	}
+	wg.Wait()
+
+	// Every room a client thinks it is in must have it as a member
+	for _, client := range clients {
+		for _, roomID := range client.RoomList() {
+			room := hub.GetRoom(roomID)
+			if room == nil {
+				t.Errorf("%s lists closed room %s", client.UserID, roomID)
+				continue
+			}
+			room.mu.RLock()
+			_, ok := room.Clients[client.UserID]
+			room.mu.RUnlock()
+			if !ok {
+				t.Errorf("%s lists room %s it is not a member of", client.UserID, roomID)
+			}
+		}
+	}
+
+	clients[0].SetMetadata("rank", "gold")
+	if v, ok := clients[0].GetMetadata("rank"); !ok || v != "gold" {
+		t.Errorf("Expected metadata value, got %v", v)
+	}
+	clients[0].DeleteMetadata("rank")
+	if _, ok := clients[0].GetMetadata("rank"); ok {
+		t.Error("Expected metadata to be deleted")
+	}
+}
+
+// countingTransport upgrades with gorilla and counts frames written
+type countingTransport struct {
+	gorilla *GorillaTransport
+	writes  chan int
+}
+
+type countingConn struct {
+	Conn
+	writes chan int
+}
+
+func (t *countingTransport) Upgrade(w http.ResponseWriter, r *http.Request) (Conn, error) {
+	conn, err := t.gorilla.Upgrade(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, writes: t.writes}, nil
+}
+
+func (c *countingConn) WriteMessage(messageType int, data []byte) error {
+	select {
+	case c.writes <- messageType:
+	default:
+	}
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+func TestTransport(t *testing.T) {
+	config := DefaultConfig()
+	transport := &countingTransport{gorilla: NewGorillaTransport(config), writes: make(chan int, 16)}
+	config.Transport = transport
+	hub := NewHub(config)
+	go hub.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleConnection(hub, w, r, "alice")
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 100 && hub.GetClient("alice") == nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := hub.SendToUser("alice", Message{Type: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case messageType := <-transport.writes:
+		if messageType != TextMessage {
+			t.Errorf("Expected a text frame, got %d", messageType)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the hub to write through the configured transport")
+	}
+
+	var msg Message
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "hello" {
+		t.Errorf("Expected hello, got %v (%v)", msg.Type, err)
+	}
+
+	// A plain HTTP request fails to upgrade
+	rec := httptest.NewRecorder()
+	if err := HandleConnection(hub, rec, httptest.NewRequest("GET", "/ws", nil), "bob"); err == nil {
+		t.Error("Expected upgrade error for a non-WebSocket request")
+	}
+}
+
+func TestParseFrame(t *testing.T) {
+	// Masked "hi" text frame
+	mask := []byte{1, 2, 3, 4}
+	data := []byte{0x81, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2}
+
+	for i := 0; i < len(data); i++ {
+		if _, n, err := parseFrame(append([]byte(nil), data[:i]...), 0); n != 0 || err != nil {
+			t.Fatalf("Expected incomplete frame at %d bytes, got n=%d err=%v", i, n, err)
+		}
+	}
+
+	f, n, err := parseFrame(append(append([]byte(nil), data...), 0x89), 0)
+	if err != nil || n != len(data) {
+		t.Fatalf("Expected one frame of %d bytes, got n=%d err=%v", len(data), n, err)
+	}
+	if !f.fin || f.opcode != TextMessage || string(f.payload) != "hi" {
+		t.Errorf("Unexpected frame %+v", f)
+	}
+
+	if _, _, err := parseFrame([]byte{0x81, 0x02, 'h', 'i'}, 0); err != errProtocol {
+		t.Errorf("Expected unmasked frame to be rejected, got %v", err)
+	}
+	if _, _, err := parseFrame([]byte{0x82, 0xfe, 0x10, 0x00}, 1024); err != errMessageTooLarge {
+		t.Errorf("Expected 4096 byte frame over the limit to be rejected, got %v", err)
+	}
+	if _, _, err := parseFrame(append([]byte{0x09, 0x80}, mask...), 0); err != errProtocol {
+		t.Errorf("Expected fragmented ping to be rejected, got %v", err)
+	}
+
+	header := appendFrameHeader(nil, BinaryMessage, 70000)
+	if len(header) != maxFrameHeader || header[0] != 0x82 || header[1] != 127 {
+		t.Errorf("Unexpected header %v", header)
+	}
+}
+
+func TestBroadcastParallel(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	hub := NewHub(nil)
+
+	clients := make([]*Client, parallelBroadcastMin+10)
+	for i := range clients {
+		clients[i] = newTestClient(hub, "user-"+strconv.Itoa(i))
+	}
+
+	hub.broadcastMessage(Message{Type: "announcement"})
+	for _, client := range clients {
+		select {
+		case <-client.Send:
+		default:
+			t.Fatalf("Expected %s to receive the broadcast", client.UserID)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	config := DefaultConfig()
+	config.Shards = 4
+	hub := NewHub(config)
+	go hub.Run()
+
+	clients := make([]*Client, 100)
+	for i := range clients {
+		clients[i] = newTestClient(hub, "user-"+strconv.Itoa(i))
+	}
+	for i := 0; i < 20; i++ {
+		hub.CreateRoomWithID("room-"+strconv.Itoa(i), &RoomConfig{Name: "room", Lifecycle: Persistent})
+	}
+
+	for i, shard := range hub.clientShards {
+		if len(shard.clients) == 0 {
+			t.Errorf("Expected users in client shard %d", i)
+		}
+	}
+	if hub.GetOnlineCount() != 100 || len(hub.GetOnlineUsers()) != 100 {
+		t.Errorf("Expected 100 users across shards, got %d", hub.GetOnlineCount())
+	}
+	if hub.GetRoomCount() != 20 || len(hub.ListAllRooms()) != 20 {
+		t.Errorf("Expected 20 rooms across shards, got %d", hub.GetRoomCount())
+	}
+	if err := hub.CreateRoomWithID("room-3", &RoomConfig{Name: "room"}); err == nil {
+		t.Error("Expected duplicate room ID to be rejected")
+	}
+
+	// Broadcasts reach every shard
+	hub.broadcastMessage(Message{Type: "announcement"})
+	for _, client := range clients {
+		select {
+		case msg := <-client.Send:
+			if msg.Type != "announcement" {
+				t.Errorf("Expected announcement, got %s", msg.Type)
+			}
+		default:
+			t.Fatalf("Expected %s to receive the broadcast", client.UserID)
+		}
+	}
+
+	hub.CloseRoom("room-3")
+	if hub.RoomExists("room-3") || hub.GetRoomCount() != 19 {
+		t.Error("Expected room to be removed from its shard")
+	}
+	hub.DisconnectUser("user-7")
+	for i := 0; i < 100 && hub.GetClient("user-7") != nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if hub.GetClient("user-7") != nil || hub.GetOnlineCount() != 99 {
+		t.Error("Expected user to be removed from its shard")
+	}
+}
+
+// BenchmarkHubMixed runs sends, lookups, room broadcasts, joins, leaves
+// and counts in parallel. Run with -race to check the locking as well:
+//
+//	go test -race -run XXX -bench HubMixed -cpu 1,4,8
+func BenchmarkHubMixed(b *testing.B) {
+	for _, shards := range []int{1, defaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			config := DefaultConfig()
+			config.Shards = shards
+			config.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+			hub := NewHub(config)
+
+			const users, rooms = 1000, 50
+			userIDs := make([]string, users)
+			roomIDs := make([]string, rooms)
+			for i := range roomIDs {
+				roomIDs[i] = hub.CreateRoom(&RoomConfig{Name: "room", Lifecycle: Persistent})
+			}
+
+			// Clients drain their sends so none is disconnected as too slow
+			done := make(chan struct{})
+			defer close(done)
+			for i := range userIDs {
+				userIDs[i] = "user-" + strconv.Itoa(i)
+				client := newTestClient(hub, userIDs[i])
+				hub.JoinRoom(userIDs[i], roomIDs[i%rooms])
+				go func() {
+					for {
+						select {
+						case _, ok := <-client.Send:
+							if !ok {
+								return
+							}
+						case <-done:
+							return
+						}
+					}
+				}()
+			}
+
+			msg := Message{Type: "bench", Data: map[string]interface{}{"n": 1}}
+			var ops atomic.Int64
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := int(ops.Add(1))
+					userID := userIDs[i%users]
+					roomID := roomIDs[i%users%rooms]
+
+					switch i % 10 {
+					case 0, 1, 2, 3:
+						hub.SendToUser(userID, msg)
+					case 4, 5:
+						hub.GetClient(userID)
+					case 6:
+						hub.BroadcastToRoom(roomID, msg)
+					case 7:
+						hub.LeaveRoom(userID, roomID)
+						hub.JoinRoom(userID, roomID)
+					case 8:
+						hub.GetOnlineCount()
+					case 9:
+						hub.GetRoomClientCount(roomID)
+					}
+				}
+			})
+		})
+	}
+}